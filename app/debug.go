@@ -0,0 +1,166 @@
+package pulltabs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"appengine"
+)
+
+// secretFields are configuration fields masked by /debug/config.
+var secretFields = map[string]bool{
+	"Secret":        true,
+	"SlackURL":      true,
+	"GitHubToken":   true,
+	"AdminToken":    true,
+	"SlackSecret":   true,
+	"PagerDutyKeys": true,
+	"URL":           true,
+	"Token":         true,
+}
+
+var locationType = reflect.TypeOf((*time.Location)(nil))
+
+// masked hides a secret value, keeping map keys and whether it is set.
+func masked(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Map {
+		out := map[string]interface{}{}
+		for _, k := range v.MapKeys() {
+			out[k.String()] = masked(v.MapIndex(k))
+		}
+		return out
+	}
+	if isZero(v) {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+func isZero(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// configView converts configuration into values encoding/json can marshal,
+// masking secrets and leaving out functions and templates.
+func configView(v reflect.Value) interface{} {
+	if v.Type() == locationType {
+		if v.IsNil() {
+			return nil
+		}
+		return v.Interface().(*time.Location).String()
+	}
+	switch v.Kind() {
+	case reflect.Func, reflect.Ptr:
+		return !v.IsNil()
+	case reflect.Struct:
+		out := map[string]interface{}{}
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			switch fv := v.Field(i); {
+			case secretFields[f.Name]:
+				out[f.Name] = masked(fv)
+			default:
+				out[f.Name] = configView(fv)
+			}
+		}
+		return out
+	case reflect.Slice:
+		out := []interface{}{}
+		for i := 0; i < v.Len(); i++ {
+			out = append(out, configView(v.Index(i)))
+		}
+		return out
+	case reflect.Map:
+		out := map[string]interface{}{}
+		for _, k := range v.MapKeys() {
+			out[fmt.Sprint(k.Interface())] = configView(v.MapIndex(k))
+		}
+		return out
+	}
+	return v.Interface()
+}
+
+func (s notifier) debugConfig(c appengine.Context, w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("CONTENT-TYPE", "application/json; charset=UTF-8")
+	w.Header().Set("CACHE-CONTROL", "max-age=0, no-cache")
+	if err := json.NewEncoder(w).Encode(configView(reflect.ValueOf(s))); err != nil {
+		c.Infof("Failed to write config for request %s. Error: %s", appengine.RequestID(c), err)
+	}
+}
+
+// recentRequest is a payload request kept for /debug/recent.
+type recentRequest struct {
+	DeliveryID string    `json:"delivery_id"`
+	Event      string    `json:"event"`
+	Outcome    string    `json:"outcome"`
+	Time       time.Time `json:"time"`
+}
+
+// ring keeps the latest requests in memory, so each instance lists only the
+// requests it served.
+type ring struct {
+	mu      sync.Mutex
+	entries []recentRequest
+	next    int
+}
+
+var recent = &ring{}
+
+// add stores a request, replacing the oldest once size are kept.
+func (r *ring) add(e recentRequest, size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.entries) < size {
+		r.entries = append(r.entries, e)
+		return
+	}
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % size
+}
+
+// list returns the kept requests, oldest first.
+func (r *ring) list() []recentRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := append([]recentRequest{}, r.entries[r.next:]...)
+	return append(out, r.entries[:r.next]...)
+}
+
+// statusRecorder remembers the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// recordPayload serves a payload request and keeps its outcome for
+// /debug/recent.
+func (s notifier) recordPayload(c appengine.Context, w http.ResponseWriter, req *http.Request) {
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	s.payload(c, rec, req)
+	recent.add(recentRequest{
+		DeliveryID: req.Header.Get("X-GitHub-Delivery"),
+		Event:      req.Header.Get("X-GitHub-Event"),
+		Outcome:    fmt.Sprintf("%d %s", rec.status, http.StatusText(rec.status)),
+		Time:       s.now(),
+	}, s.RecentSize)
+}
+
+func (s notifier) debugRecent(c appengine.Context, w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("CONTENT-TYPE", "application/json; charset=UTF-8")
+	w.Header().Set("CACHE-CONTROL", "max-age=0, no-cache")
+	if err := json.NewEncoder(w).Encode(recent.list()); err != nil {
+		c.Infof("Failed to write recent requests for request %s. Error: %s", appengine.RequestID(c), err)
+	}
+}
@@ -0,0 +1,119 @@
+package pulltabs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	texttemplate "text/template"
+	"time"
+
+	"appengine/aetest"
+)
+
+func TestDebugConfigRedacts(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	secrets := []string{"hub-secret", "https://hooks.slack.com/services/T0/B0/XXXX", "ghp_token", "admin-token", "slack-signing", "pd-key", "xoxb-token", "https://discord.com/api/webhooks/1/abc", "sign-key"}
+	s := notifier{
+		Label:         "awaiting review",
+		Secret:        secrets[0],
+		SlackURL:      secrets[1],
+		GitHubToken:   secrets[2],
+		AdminToken:    secrets[3],
+		SlackSecret:   secrets[4],
+		PagerDutyKeys: map[string]string{"hotfix": secrets[5]},
+		Backends: []Backend{
+			Backend{Type: "slackbot", Token: secrets[6], Channel: "#reviews"},
+			Backend{Type: "webhook", URL: secrets[7], Secret: secrets[8], Topics: Templates{"go": texttemplate.Must(texttemplate.New("go").Parse("{{ .Message }}"))}},
+		},
+		Location: time.UTC,
+		Now:      time.Now,
+	}
+	w := httptest.NewRecorder()
+	s.debugConfig(c, w, httptest.NewRequest("GET", "/debug/config", nil))
+	out := w.Body.String()
+	for _, secret := range secrets {
+		if strings.Contains(out, secret) {
+			t.Errorf("config shows the secret %q", secret)
+		}
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config["Label"] != "awaiting review" || config["Location"] != "UTC" || config["Now"] != true {
+		t.Errorf("config = %s, want the label, location and clock", out)
+	}
+	if keys := config["PagerDutyKeys"].(map[string]interface{}); keys["hotfix"] != "[REDACTED]" {
+		t.Errorf("PagerDutyKeys = %v, want the hotfix key redacted", keys)
+	}
+	backends := config["Backends"].([]interface{})
+	bot, hook := backends[0].(map[string]interface{}), backends[1].(map[string]interface{})
+	if bot["Token"] != "[REDACTED]" || bot["Channel"] != "#reviews" {
+		t.Errorf("slackbot backend = %v, want the token redacted", bot)
+	}
+	if topics := hook["Topics"].(map[string]interface{}); topics["go"] != true {
+		t.Errorf("Topics = %v, want the go template shown as set", topics)
+	}
+	if hook["Template"] != false {
+		t.Errorf("Template = %v, want unset", hook["Template"])
+	}
+}
+
+func TestRing(t *testing.T) {
+	r := &ring{}
+	for i := 1; i <= 5; i++ {
+		r.add(recentRequest{DeliveryID: fmt.Sprint(i)}, 3)
+	}
+	var ids []string
+	for _, e := range r.list() {
+		ids = append(ids, e.DeliveryID)
+	}
+	if got := strings.Join(ids, ","); got != "3,4,5" {
+		t.Errorf("recent requests = %s, want 3,4,5", got)
+	}
+}
+
+func TestRecordPayload(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	defer func(saved *ring) { recent = saved }(recent)
+	recent = &ring{}
+
+	now := time.Date(2026, time.October, 14, 9, 0, 0, 0, time.UTC)
+	s := notifier{RecentSize: 2, Now: func() time.Time { return now }}
+	for i, event := range []string{"ping", "deployment", "ping"} {
+		req := httptest.NewRequest("POST", "/payload", strings.NewReader(`{}`))
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-GitHub-Delivery", fmt.Sprint(i+1))
+		s.recordPayload(c, httptest.NewRecorder(), req)
+	}
+	w := httptest.NewRecorder()
+	s.debugRecent(c, w, httptest.NewRequest("GET", "/debug/recent", nil))
+	var got []recentRequest
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []recentRequest{
+		recentRequest{DeliveryID: "2", Event: "deployment", Outcome: "400 Bad Request", Time: now},
+		recentRequest{DeliveryID: "3", Event: "ping", Outcome: "200 OK", Time: now},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("recent requests = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].DeliveryID != want[i].DeliveryID || got[i].Event != want[i].Event || got[i].Outcome != want[i].Outcome || !got[i].Time.Equal(want[i].Time) {
+			t.Errorf("recent request %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package pulltabs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"appengine"
+	"appengine/urlfetch"
+)
+
+type searchItem struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	HTMLURL string `json:"html_url"`
+}
+
+type searchResult struct {
+	Items []searchItem `json:"items"`
+}
+
+// digestGroup is the open pull requests carrying one label.
+type digestGroup struct {
+	Label string
+	Items []searchItem
+}
+
+func (s notifier) digestLabels() []string {
+	if len(s.DigestLabels) > 0 {
+		return s.DigestLabels
+	}
+	return []string{s.Label}
+}
+
+// digestGroups searches the digest repositories for open pull requests with
+// each digest label.
+func (s notifier) digestGroups(client *http.Client) ([]digestGroup, error) {
+	var groups []digestGroup
+	for _, label := range s.digestLabels() {
+		q := fmt.Sprintf("is:pr is:open label:%q", label)
+		for _, repo := range s.DigestRepos {
+			q += " repo:" + repo
+		}
+		var res searchResult
+		if err := s.githubGet(client, "/search/issues?per_page=100&q="+url.QueryEscape(q), &res); err != nil {
+			return nil, err
+		}
+		groups = append(groups, digestGroup{Label: label, Items: res.Items})
+	}
+	return groups, nil
+}
+
+func slackLink(item searchItem) string {
+	return fmt.Sprintf("<%s|%s>", item.HTMLURL, slackEscape(item.Title))
+}
+
+func discordLink(item searchItem) string {
+	return fmt.Sprintf("[%s](%s)", item.Title, item.HTMLURL)
+}
+
+// digestText lists the pull requests in the groups, under a heading per label
+// when DigestByLabel is set. Pull requests with several labels are listed once
+// otherwise. It returns an empty string when there are no pull requests.
+func (s notifier) digestText(groups []digestGroup, link func(searchItem) string) string {
+	var sections []string
+	if s.DigestByLabel {
+		for _, g := range groups {
+			if len(g.Items) == 0 {
+				continue
+			}
+			var lines []string
+			for _, item := range g.Items {
+				lines = append(lines, "• "+link(item))
+			}
+			sections = append(sections, fmt.Sprintf("*%s*\n%s", g.Label, strings.Join(lines, "\n")))
+		}
+	} else {
+		seen := map[string]bool{}
+		var lines []string
+		for _, g := range groups {
+			for _, item := range g.Items {
+				if !seen[item.HTMLURL] {
+					seen[item.HTMLURL] = true
+					lines = append(lines, "• "+link(item))
+				}
+			}
+		}
+		if len(lines) > 0 {
+			sections = append(sections, strings.Join(lines, "\n"))
+		}
+	}
+	if len(sections) == 0 {
+		return ""
+	}
+	return s.tr("digest") + "\n\n" + strings.Join(sections, "\n\n")
+}
+
+func (s notifier) emptyMessage() string {
+	if s.EmptyMessage != "" {
+		return s.EmptyMessage
+	}
+	return s.tr("empty")
+}
+
+// digestChunks splits the groups so that no chunk has more than DigestChunk
+// pull requests. Without DigestByLabel the groups are first merged into one,
+// so that pull requests with several labels are counted once.
+func (s notifier) digestChunks(groups []digestGroup) [][]digestGroup {
+	if !s.DigestByLabel {
+		var merged digestGroup
+		seen := map[string]bool{}
+		for _, g := range groups {
+			for _, item := range g.Items {
+				if !seen[item.HTMLURL] {
+					seen[item.HTMLURL] = true
+					merged.Items = append(merged.Items, item)
+				}
+			}
+		}
+		groups = []digestGroup{merged}
+	}
+	if s.DigestChunk <= 0 {
+		return [][]digestGroup{groups}
+	}
+	var chunks [][]digestGroup
+	var chunk []digestGroup
+	n := 0
+	for _, g := range groups {
+		items := g.Items
+		for len(items) > 0 {
+			if n == s.DigestChunk {
+				chunks = append(chunks, chunk)
+				chunk, n = nil, 0
+			}
+			take := s.DigestChunk - n
+			if take > len(items) {
+				take = len(items)
+			}
+			chunk = append(chunk, digestGroup{Label: g.Label, Items: items[:take]})
+			items = items[take:]
+			n += take
+		}
+	}
+	return append(chunks, chunk)
+}
+
+// digestBodies renders the digest for a backend, as one message per chunk.
+func (s notifier) digestBodies(b Backend, groups []digestGroup) ([]string, error) {
+	link := slackLink
+	if b.Type == "discord" {
+		link = discordLink
+	}
+	var bodies []string
+	for _, chunk := range s.digestChunks(groups) {
+		text := s.digestText(chunk, link)
+		if text == "" {
+			continue
+		}
+		body, err := s.textBody(b, text)
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, body)
+	}
+	if len(bodies) == 0 {
+		body, err := s.textBody(b, s.emptyMessage())
+		return []string{body}, err
+	}
+	return bodies, nil
+}
+
+// textBody renders a plain text message for a backend.
+func (s notifier) textBody(b Backend, text string) (string, error) {
+	text = s.prefixed(text)
+	var out []byte
+	var err error
+	switch b.Type {
+	case "discord":
+		out, err = json.Marshal(&discordMessage{Content: text})
+	case "telegram":
+		out, err = json.Marshal(&telegramMessage{ChatID: b.Channel, Text: telegramHTML(text), ParseMode: "HTML"})
+	default:
+		out, err = json.Marshal(&slackMessage{Channel: b.Channel, Text: text})
+	}
+	return string(out), err
+}
+
+func (s notifier) digest(c appengine.Context, w http.ResponseWriter, req *http.Request) {
+	reqID := appengine.RequestID(c)
+	if req.Header.Get("X-AppEngine-Cron") == "" {
+		c.Infof("Rejecting digest request %s not sent by cron", reqID)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if s.inMaintenance(c) {
+		c.Infof("Suppressing digest request %s during maintenance", reqID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if len(s.DigestRepos) == 0 {
+		c.Infof("No digest repositories configured for request %s", reqID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	client := urlfetch.Client(c)
+	groups, err := s.digestGroups(client)
+	if err != nil {
+		c.Infof("Failed to search pull requests for request %s. Error: %s", reqID, err)
+		http.Error(w, "Failed to search pull requests", http.StatusInternalServerError)
+		return
+	}
+	if s.digestText(groups, slackLink) == "" && !s.DigestEmpty {
+		c.Infof("No open pull requests for digest request %s", reqID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	for _, b := range s.backends() {
+		if b.Type == "pubsub" {
+			continue
+		}
+		bodies, err := s.digestBodies(b, groups)
+		for _, body := range bodies {
+			if err != nil {
+				break
+			}
+			if b.Type == "slackbot" {
+				s.throttle(b.Channel)
+			}
+			_, err = postBody(client, b, body)
+		}
+		if err != nil {
+			c.Infof("Failed to post %s digest for request %s. Error: %s", b.Type, reqID, err)
+		}
+	}
+	c.Infof("Successful handling of digest for request %s", reqID)
+	w.WriteHeader(http.StatusOK)
+}
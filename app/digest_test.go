@@ -0,0 +1,108 @@
+package pulltabs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func digestItem(n int) searchItem {
+	return searchItem{
+		Number:  n,
+		Title:   fmt.Sprintf("Pull request %d", n),
+		HTMLURL: fmt.Sprintf("https://github.com/octo/repo/pull/%d", n),
+	}
+}
+
+func TestDigestByLabel(t *testing.T) {
+	groups := []digestGroup{
+		digestGroup{Label: "awaiting review", Items: []searchItem{digestItem(1), digestItem(2)}},
+		digestGroup{Label: "urgent", Items: []searchItem{digestItem(2), digestItem(3)}},
+	}
+	want := locales["en"]["digest"] + "\n\n" +
+		"*awaiting review*\n" +
+		"• <https://github.com/octo/repo/pull/1|Pull request 1>\n" +
+		"• <https://github.com/octo/repo/pull/2|Pull request 2>\n\n" +
+		"*urgent*\n" +
+		"• <https://github.com/octo/repo/pull/2|Pull request 2>\n" +
+		"• <https://github.com/octo/repo/pull/3|Pull request 3>"
+	if got := (notifier{DigestByLabel: true}).digestText(groups, slackLink); got != want {
+		t.Errorf("grouped digest = %q, want %q", got, want)
+	}
+
+	want = locales["en"]["digest"] + "\n\n" +
+		"• <https://github.com/octo/repo/pull/1|Pull request 1>\n" +
+		"• <https://github.com/octo/repo/pull/2|Pull request 2>\n" +
+		"• <https://github.com/octo/repo/pull/3|Pull request 3>"
+	if got := (notifier{}).digestText(groups, slackLink); got != want {
+		t.Errorf("ungrouped digest = %q, want %q", got, want)
+	}
+}
+
+func TestEmptyDigest(t *testing.T) {
+	groups := []digestGroup{digestGroup{Label: "awaiting review"}}
+	// The digest handler stays silent when there is no digest text, unless
+	// DigestEmpty is set.
+	if text := (notifier{}).digestText(groups, slackLink); text != "" {
+		t.Fatalf("empty digest text = %q, want none", text)
+	}
+
+	s := notifier{DigestEmpty: true, EmptyMessage: "Nothing to review today"}
+	bodies, err := s.digestBodies(Backend{Type: "slack"}, groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m slackMessage
+	if len(bodies) != 1 {
+		t.Fatalf("posted %d messages, want 1", len(bodies))
+	}
+	if err := json.Unmarshal([]byte(bodies[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Text != s.EmptyMessage {
+		t.Errorf("empty digest = %q, want %q", m.Text, s.EmptyMessage)
+	}
+	if got := (notifier{}).emptyMessage(); got != "The review queue is empty :tada:" {
+		t.Errorf("default empty message = %q, want %q", got, "The review queue is empty :tada:")
+	}
+}
+
+func TestDigestChunks(t *testing.T) {
+	var items []searchItem
+	for n := 1; n <= 25; n++ {
+		items = append(items, digestItem(n))
+	}
+	groups := []digestGroup{digestGroup{Label: "awaiting review", Items: items}}
+
+	s := notifier{DigestChunk: 10}
+	bodies, err := s.digestBodies(Backend{Type: "slack"}, groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 3 {
+		t.Fatalf("digest posted %d messages, want 3", len(bodies))
+	}
+	for i, want := range []int{10, 10, 5} {
+		if n := strings.Count(bodies[i], "• "); n != want {
+			t.Errorf("message %d lists %d pull requests, want %d", i, n, want)
+		}
+	}
+	if !strings.Contains(bodies[2], "/pull/25|") || strings.Contains(bodies[2], "/pull/20|") {
+		t.Errorf("last message = %s, want pull requests 21 to 25", bodies[2])
+	}
+
+	grouped := append(groups, digestGroup{Label: "urgent", Items: []searchItem{digestItem(26), digestItem(27)}})
+	chunks := (notifier{DigestChunk: 10, DigestByLabel: true}).digestChunks(grouped)
+	if len(chunks) != 3 || len(chunks[2]) != 2 || chunks[2][1].Label != "urgent" {
+		t.Errorf("grouped chunks = %v, want the urgent group in the last chunk", chunks)
+	}
+
+	bodies, err = (notifier{}).digestBodies(Backend{Type: "slack"}, groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 1 {
+		t.Errorf("unchunked digest posted %d messages, want 1", len(bodies))
+	}
+}
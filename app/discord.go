@@ -0,0 +1,69 @@
+package pulltabs
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// slackLinkPattern matches links in Slack's <url|text> format.
+var slackLinkPattern = regexp.MustCompile(`<(https?://[^|>]+)\|([^>]+)>`)
+
+// discordLinks rewrites Slack links as Markdown links for Discord, and
+// unescapes the rest of the Slack text.
+func discordLinks(text string) string {
+	return slackUnescaper.Replace(slackLinkPattern.ReplaceAllString(text, "[$2]($1)"))
+}
+
+// discordGood matches the color of Slack's "good" attachments.
+const discordGood = 0x2eb886
+
+type discordImage struct {
+	URL string `json:"url"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title,omitempty"`
+	URL         string         `json:"url,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Color       int            `json:"color,omitempty"`
+	Image       *discordImage  `json:"image,omitempty"`
+	Fields      []discordField `json:"fields,omitempty"`
+}
+
+type discordMessage struct {
+	Content string         `json:"content"`
+	Embeds  []discordEmbed `json:"embeds,omitempty"`
+}
+
+func (s notifier) discordOutput(pr pullRequestPost, d prDetails) ([]byte, error) {
+	e := discordEmbed{
+		Title:       pr.PullRequest.Title,
+		URL:         pr.PullRequest.HTMLURL,
+		Description: discordLinks(strings.Join(s.details(pr, d), "\n")),
+		Color:       discordGood,
+	}
+	if u, ok := s.urgency(pr); ok {
+		e.Color = u.Discord
+	}
+	if s.breached(pr) {
+		e.Color = discordColors["danger"]
+	}
+	for _, f := range s.fields(pr, d) {
+		e.Fields = append(e.Fields, discordField{Name: f.Title, Value: discordLinks(f.Value), Inline: f.Short})
+	}
+	if u := s.imageURL(pr); u != "" {
+		e.Image = &discordImage{URL: u}
+	}
+	m := discordMessage{
+		Content: s.text(pr),
+		Embeds:  []discordEmbed{e},
+	}
+	return json.Marshal(&m)
+}
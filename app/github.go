@@ -0,0 +1,406 @@
+package pulltabs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"appengine"
+)
+
+const githubAPIURL = "https://api.github.com"
+
+const (
+	// ciPollInterval is how long to wait before checking pending CI again.
+	ciPollInterval = 5 * time.Minute
+	// ciMaxChecks limits how often CI is checked for one pull request.
+	ciMaxChecks = 288
+)
+
+// decisionBadges is the locale key describing each GraphQL review decision.
+var decisionBadges = map[string]string{
+	"APPROVED":          "approved",
+	"CHANGES_REQUESTED": "changes requested",
+	"REVIEW_REQUIRED":   "review required",
+}
+
+const reviewDecisionQuery = `query($owner: String!, $name: String!, $number: Int!) {
+  repository(owner: $owner, name: $name) {
+    pullRequest(number: $number) {
+      reviewDecision
+    }
+  }
+}`
+
+// projectItemsQuery finds the projects a pull request is in, along with the
+// value of each project's "Status" field, which is its board column.
+const projectItemsQuery = `query($owner: String!, $name: String!, $number: Int!) {
+  repository(owner: $owner, name: $name) {
+    pullRequest(number: $number) {
+      projectItems(first: 10) {
+        nodes {
+          project {
+            title
+          }
+          fieldValueByName(name: "Status") {
+            ... on ProjectV2ItemFieldSingleSelectValue {
+              name
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+type review struct {
+	State       string    `json:"state"`
+	CommitID    string    `json:"commit_id"`
+	SubmittedAt time.Time `json:"submitted_at"`
+	User        struct {
+		Login string `json:"login"`
+	} `json:"user"`
+}
+
+// prDetails holds pull request data that is not part of the webhook payload,
+// mostly fetched from the GitHub API.
+type prDetails struct {
+	Reviews        []review
+	ReviewDecision string
+	Responder      string
+	Files          []string
+	SlackUsers     map[string]string // Slack user IDs looked up by GitHub login
+	Projects       []projectColumn
+	ThreadTS       string         // Daily label thread to post in, for "slackbot"
+	Workload       map[string]int // Open review requests by reviewer
+	LastActivity   time.Time      // When the head commit was made
+}
+
+// projectColumn is a project a pull request is in and its column there, empty
+// when the status is not set.
+type projectColumn struct {
+	Project string
+	Column  string
+}
+
+func (s notifier) githubGet(client *http.Client, path string, v interface{}) error {
+	req, err := http.NewRequest("GET", githubAPIURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if s.GitHubToken != "" {
+		req.Header.Set("Authorization", "token "+s.GitHubToken)
+	}
+	r, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", path, r.Status)
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+func (s notifier) githubDelete(client *http.Client, path string) error {
+	req, err := http.NewRequest("DELETE", githubAPIURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "token "+s.GitHubToken)
+	r, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK && r.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("DELETE %s: %s", path, r.Status)
+	}
+	return nil
+}
+
+// githubGraphQL runs a GraphQL query, decoding the data of the response into v.
+func (s notifier) githubGraphQL(client *http.Client, query string, vars map[string]interface{}, v interface{}) error {
+	b, err := json.Marshal(map[string]interface{}{"query": query, "variables": vars})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", githubAPIURL+"/graphql", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "bearer "+s.GitHubToken)
+	r, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("POST /graphql: %s", r.Status)
+	}
+	res := struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		return err
+	}
+	if len(res.Errors) > 0 {
+		return fmt.Errorf("POST /graphql: %s", res.Errors[0].Message)
+	}
+	return json.Unmarshal(res.Data, v)
+}
+
+// fetchDetails requests the API data needed by the enabled message options.
+// Failures are logged and leave the matching details empty.
+func (s notifier) fetchDetails(c appengine.Context, client *http.Client, pr pullRequestPost) prDetails {
+	var d prDetails
+	pullPath := fmt.Sprintf("/repos/%s/pulls/%d", pr.Repository.FullName, pr.Number)
+	if s.ReviewCounts || s.ShowChanges {
+		if err := s.githubGet(client, pullPath+"/reviews?per_page=100", &d.Reviews); err != nil {
+			c.Infof("Failed to fetch reviews for request %s. Error: %s", appengine.RequestID(c), err)
+		}
+	}
+	if s.ShowDecision {
+		if decision, err := s.reviewDecision(client, pr); err != nil {
+			c.Infof("Failed to fetch review decision for request %s. Error: %s", appengine.RequestID(c), err)
+		} else {
+			d.ReviewDecision = decision
+		}
+	}
+	if s.ShowActivity {
+		if at, err := s.headCommitTime(client, pr); err != nil {
+			c.Infof("Failed to fetch head commit for request %s. Error: %s", appengine.RequestID(c), err)
+		} else {
+			d.LastActivity = at
+		}
+	}
+	if s.ShowWorkload {
+		if counts, err := workload(c); err != nil {
+			c.Infof("Failed to count review requests for request %s. Error: %s", appengine.RequestID(c), err)
+		} else {
+			d.Workload = counts
+		}
+	}
+	if s.ShowProject {
+		if projects, err := s.projectColumns(client, pr); err != nil {
+			c.Infof("Failed to fetch projects for request %s. Error: %s", appengine.RequestID(c), err)
+		} else {
+			d.Projects = projects
+		}
+	}
+	if len(s.Responders) > 0 {
+		if responder, err := nextResponder(c, s.Responders); err != nil {
+			c.Infof("Failed to pick a responder for request %s. Error: %s", appengine.RequestID(c), err)
+		} else {
+			d.Responder = responder
+		}
+	}
+	var logins []string
+	if s.Mentions {
+		logins = mentions(pr)
+	}
+	if d.Responder != "" {
+		logins = append(logins, d.Responder)
+	}
+	d.SlackUsers = s.slackUsers(c, client, logins)
+	if len(s.FileRoutes) > 0 {
+		files, err := s.changedFiles(client, pr.Repository.FullName, pr.Number)
+		if err != nil {
+			c.Infof("Failed to fetch changed files for request %s. Error: %s", appengine.RequestID(c), err)
+		}
+		d.Files = files
+	}
+	return d
+}
+
+func (s notifier) projectColumns(client *http.Client, pr pullRequestPost) ([]projectColumn, error) {
+	parts := strings.SplitN(pr.Repository.FullName, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid repository name %q", pr.Repository.FullName)
+	}
+	var res struct {
+		Repository struct {
+			PullRequest struct {
+				ProjectItems struct {
+					Nodes []struct {
+						Project struct {
+							Title string `json:"title"`
+						} `json:"project"`
+						FieldValueByName struct {
+							Name string `json:"name"`
+						} `json:"fieldValueByName"`
+					} `json:"nodes"`
+				} `json:"projectItems"`
+			} `json:"pullRequest"`
+		} `json:"repository"`
+	}
+	vars := map[string]interface{}{"owner": parts[0], "name": parts[1], "number": pr.Number}
+	if err := s.githubGraphQL(client, projectItemsQuery, vars, &res); err != nil {
+		return nil, err
+	}
+	var projects []projectColumn
+	for _, n := range res.Repository.PullRequest.ProjectItems.Nodes {
+		projects = append(projects, projectColumn{Project: n.Project.Title, Column: n.FieldValueByName.Name})
+	}
+	return projects, nil
+}
+
+// headCommitTime is when the head commit of a pull request was committed.
+func (s notifier) headCommitTime(client *http.Client, pr pullRequestPost) (time.Time, error) {
+	var commit struct {
+		Commit struct {
+			Committer struct {
+				Date time.Time `json:"date"`
+			} `json:"committer"`
+		} `json:"commit"`
+	}
+	path := fmt.Sprintf("/repos/%s/commits/%s", pr.Repository.FullName, pr.PullRequest.Head.SHA)
+	err := s.githubGet(client, path, &commit)
+	return commit.Commit.Committer.Date, err
+}
+
+// changedFiles lists the names of the files a pull request changes, up to the
+// first hundred.
+func (s notifier) changedFiles(client *http.Client, repo string, number int) ([]string, error) {
+	var files []struct {
+		Filename string `json:"filename"`
+	}
+	path := fmt.Sprintf("/repos/%s/pulls/%d/files?per_page=100", repo, number)
+	if err := s.githubGet(client, path, &files); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.Filename
+	}
+	return names, nil
+}
+
+func (s notifier) reviewDecision(client *http.Client, pr pullRequestPost) (string, error) {
+	parts := strings.SplitN(pr.Repository.FullName, "/", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid repository name %q", pr.Repository.FullName)
+	}
+	var res struct {
+		Repository struct {
+			PullRequest struct {
+				ReviewDecision string `json:"reviewDecision"`
+			} `json:"pullRequest"`
+		} `json:"repository"`
+	}
+	vars := map[string]interface{}{"owner": parts[0], "name": parts[1], "number": pr.Number}
+	if err := s.githubGraphQL(client, reviewDecisionQuery, vars, &res); err != nil {
+		return "", err
+	}
+	return res.Repository.PullRequest.ReviewDecision, nil
+}
+
+// changesStatus reports whether changes requested on a pull request are still
+// outstanding or have all been addressed, going by the latest review of each
+// reviewer. A request for changes is addressed once its review is dismissed or
+// was left on an older commit than the head of the pull request.
+func changesStatus(pr pullRequestPost, reviews []review) (outstanding, addressed bool) {
+	latest := map[string]review{}
+	changed := map[string]bool{}
+	for _, r := range reviews {
+		switch r.State {
+		case "CHANGES_REQUESTED":
+			changed[r.User.Login] = true
+			fallthrough
+		case "APPROVED", "DISMISSED":
+			latest[r.User.Login] = r
+		}
+	}
+	for login := range changed {
+		r := latest[login]
+		switch {
+		case r.State == "CHANGES_REQUESTED" && (r.CommitID == "" || r.CommitID == pr.PullRequest.Head.SHA):
+			return true, false
+		case r.State == "CHANGES_REQUESTED" || r.State == "DISMISSED":
+			addressed = true
+		}
+	}
+	return false, addressed
+}
+
+// reviewProgress counts the reviewers who have approved or requested changes
+// out of everyone asked to review. Reviewers who have been requested again
+// since their last review are counted as outstanding.
+func reviewProgress(pr pullRequestPost, reviews []review) (done, total int) {
+	requested := map[string]bool{}
+	for _, r := range pr.PullRequest.RequestedReviewers {
+		requested[r.Login] = true
+	}
+	reviewed := map[string]bool{}
+	for _, r := range reviews {
+		if (r.State == "APPROVED" || r.State == "CHANGES_REQUESTED") && !requested[r.User.Login] {
+			reviewed[r.User.Login] = true
+		}
+	}
+	return len(reviewed), len(requested) + len(reviewed)
+}
+
+// ciState combines the commit statuses and check runs for the head of a pull
+// request into "success", "pending" or "failure".
+func (s notifier) ciState(client *http.Client, pr pullRequestPost) (string, error) {
+	commitPath := fmt.Sprintf("/repos/%s/commits/%s", pr.Repository.FullName, pr.PullRequest.Head.SHA)
+	var status struct {
+		State      string `json:"state"`
+		TotalCount int    `json:"total_count"`
+	}
+	if err := s.githubGet(client, commitPath+"/status", &status); err != nil {
+		return "", err
+	}
+	var checks struct {
+		CheckRuns []struct {
+			Status     string `json:"status"`
+			Conclusion string `json:"conclusion"`
+		} `json:"check_runs"`
+	}
+	if err := s.githubGet(client, commitPath+"/check-runs?per_page=100", &checks); err != nil {
+		return "", err
+	}
+	state := "success"
+	if status.TotalCount > 0 && status.State != "success" {
+		state = status.State
+	}
+	if state == "failure" || state == "error" {
+		return "failure", nil
+	}
+	for _, run := range checks.CheckRuns {
+		switch {
+		case run.Status != "completed":
+			state = "pending"
+		case run.Conclusion == "failure" || run.Conclusion == "cancelled" || run.Conclusion == "timed_out" || run.Conclusion == "action_required":
+			return "failure", nil
+		}
+	}
+	return state, nil
+}
+
+// removeLabels takes the watched label off a pull request now that someone
+// has picked it up.
+func (s notifier) removeLabels(c appengine.Context, client *http.Client, pr pullRequestPost) {
+	reqID := appengine.RequestID(c)
+	for _, l := range pr.PullRequest.Labels {
+		if !strings.Contains(l.Name, s.Label) {
+			continue
+		}
+		path := fmt.Sprintf("/repos/%s/issues/%d/labels/%s", pr.Repository.FullName, pr.Number, url.PathEscape(l.Name))
+		if err := s.githubDelete(client, path); err != nil {
+			c.Infof("Failed to remove label %s for request %s. Error: %s", l.Name, reqID, err)
+			continue
+		}
+		c.Infof("Removed label %s for request %s", l.Name, reqID)
+	}
+}
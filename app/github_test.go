@@ -0,0 +1,210 @@
+package pulltabs
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"appengine/aetest"
+)
+
+func reviewBy(login, state string) review {
+	r := review{State: state}
+	r.User.Login = login
+	return r
+}
+
+func TestReviewProgress(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested string
+		reviews   []review
+		done      int
+		total     int
+	}{
+		{"none reviewed", `[{"login": "alice"}, {"login": "bob"}]`, nil, 0, 2},
+		{"one approved", `[{"login": "bob"}]`, []review{reviewBy("alice", "APPROVED")}, 1, 2},
+		{"both reviewed", `[]`, []review{reviewBy("alice", "APPROVED"), reviewBy("bob", "CHANGES_REQUESTED")}, 2, 2},
+		{"comments only", `[{"login": "alice"}, {"login": "bob"}]`, []review{reviewBy("alice", "COMMENTED")}, 0, 2},
+		{"requested again", `[{"login": "alice"}, {"login": "bob"}]`, []review{reviewBy("alice", "APPROVED")}, 0, 2},
+	}
+	for _, tt := range tests {
+		pr := testEvent(t, `{"pull_request": {"requested_reviewers": `+tt.requested+`}}`)
+		done, total := reviewProgress(pr, tt.reviews)
+		if done != tt.done || total != tt.total {
+			t.Errorf("%s: reviewProgress = %d/%d, want %d/%d", tt.name, done, total, tt.done, tt.total)
+		}
+	}
+}
+
+func TestReviewDecision(t *testing.T) {
+	for decision, badge := range map[string]string{
+		"APPROVED":          "✅ Approved",
+		"CHANGES_REQUESTED": "❌ Changes requested",
+		"REVIEW_REQUIRED":   "👀 Review required",
+		"":                  "",
+	} {
+		client, st := stubClient(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"data": {"repository": {"pullRequest": {"reviewDecision": %q}}}}`, decision)
+		})
+		s := notifier{ShowDecision: true, GitHubToken: "token"}
+		pr := testEvent(t, `{"number": 5, "repository": {"full_name": "octo/repo"}}`)
+		got, err := s.reviewDecision(client, pr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != decision {
+			t.Errorf("reviewDecision = %q, want %q", got, decision)
+		}
+		if path := st.requests[0].URL.Path; path != "/graphql" {
+			t.Errorf("requested %s, want /graphql", path)
+		}
+		if !strings.Contains(st.bodies[0], `"number":5`) || !strings.Contains(st.bodies[0], `"owner":"octo"`) {
+			t.Errorf("query variables = %s, want octo/repo#5", st.bodies[0])
+		}
+		lines := s.details(pr, prDetails{ReviewDecision: got})
+		if badge == "" && len(lines) != 0 {
+			t.Errorf("%q: details = %q, want none", decision, lines)
+		}
+		if badge != "" && (len(lines) != 1 || lines[0] != badge) {
+			t.Errorf("%q: details = %q, want %q", decision, lines, badge)
+		}
+	}
+}
+
+func TestCIState(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		checks string
+		want   string
+	}{
+		{"no CI", `{"state": "pending", "total_count": 0}`, `{"check_runs": []}`, "success"},
+		{"checks running", `{"state": "success", "total_count": 1}`, `{"check_runs": [{"status": "completed", "conclusion": "success"}, {"status": "in_progress"}]}`, "pending"},
+		{"status pending", `{"state": "pending", "total_count": 1}`, `{"check_runs": []}`, "pending"},
+		{"passed", `{"state": "success", "total_count": 1}`, `{"check_runs": [{"status": "completed", "conclusion": "success"}, {"status": "completed", "conclusion": "skipped"}]}`, "success"},
+		{"check failed", `{"state": "success", "total_count": 1}`, `{"check_runs": [{"status": "in_progress"}, {"status": "completed", "conclusion": "failure"}]}`, "failure"},
+		{"status error", `{"state": "error", "total_count": 1}`, `{"check_runs": []}`, "failure"},
+	}
+	pr := testEvent(t, `{"repository": {"full_name": "octo/repo"}, "pull_request": {"head": {"sha": "6dcb09b"}}}`)
+	for _, tt := range tests {
+		client, _ := stubClient(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/repos/octo/repo/commits/6dcb09b/status":
+				io.WriteString(w, tt.status)
+			case "/repos/octo/repo/commits/6dcb09b/check-runs":
+				io.WriteString(w, tt.checks)
+			default:
+				http.NotFound(w, r)
+			}
+		})
+		got, err := notifier{}.ciState(client, pr)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: ciState = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveLabels(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	client, st := stubClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, `[]`)
+	})
+	s := notifier{Label: "awaiting review", RemoveLabel: true, GitHubToken: "ghp_token"}
+	pr := testEvent(t, `{"action": "assigned", "number": 12, "repository": {"full_name": "octo/repo"}, "pull_request": {"labels": [{"name": "backend"}, {"name": "awaiting review"}]}}`)
+	s.removeLabels(c, client, pr)
+
+	if len(st.requests) != 1 {
+		t.Fatalf("made %d requests, want 1", len(st.requests))
+	}
+	req := st.requests[0]
+	if req.Method != "DELETE" || req.URL.EscapedPath() != "/repos/octo/repo/issues/12/labels/awaiting%20review" {
+		t.Errorf("request = %s %s, want the label removed", req.Method, req.URL.EscapedPath())
+	}
+	if auth := req.Header.Get("Authorization"); auth != "token ghp_token" {
+		t.Errorf("Authorization = %q, want the GitHub token", auth)
+	}
+}
+
+func TestHeadCommitTime(t *testing.T) {
+	client, st := stubClient(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"sha": "6dcb09b", "commit": {"committer": {"date": "2026-10-13T08:30:00Z"}}}`)
+	})
+	pr := testEvent(t, `{"repository": {"full_name": "octo/repo"}, "pull_request": {"head": {"sha": "6dcb09b"}}}`)
+	at, err := notifier{}.headCommitTime(client, pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, time.October, 13, 8, 30, 0, 0, time.UTC); !at.Equal(want) {
+		t.Errorf("headCommitTime = %s, want %s", at, want)
+	}
+	if path := st.requests[0].URL.Path; path != "/repos/octo/repo/commits/6dcb09b" {
+		t.Errorf("requested %s, want the head commit", path)
+	}
+}
+
+func TestProjectColumns(t *testing.T) {
+	for _, tc := range []struct {
+		nodes string
+		want  []string
+	}{
+		{`[{"project": {"title": "Board"}, "fieldValueByName": {"name": "In review"}}]`, []string{"Project: Board › In review"}},
+		{`[{"project": {"title": "Roadmap"}, "fieldValueByName": null}]`, []string{"Project: Roadmap"}},
+		{`[]`, nil},
+	} {
+		client, _ := stubClient(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"data": {"repository": {"pullRequest": {"projectItems": {"nodes": %s}}}}}`, tc.nodes)
+		})
+		s := notifier{ShowProject: true, GitHubToken: "token"}
+		pr := testEvent(t, `{"number": 5, "repository": {"full_name": "octo/repo"}}`)
+		projects, err := s.projectColumns(client, pr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := s.details(pr, prDetails{Projects: projects})
+		if fmt.Sprint(lines) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: details = %q, want %q", tc.nodes, lines, tc.want)
+		}
+	}
+}
+
+func TestChangesStatus(t *testing.T) {
+	on := func(r review, sha string) review {
+		r.CommitID = sha
+		return r
+	}
+	pr := testEvent(t, `{"pull_request": {"head": {"sha": "b2"}}}`)
+	for _, tc := range []struct {
+		name    string
+		reviews []review
+		line    string
+	}{
+		{"none", nil, ""},
+		{"approved", []review{reviewBy("alice", "APPROVED")}, ""},
+		{"outstanding on head", []review{on(reviewBy("alice", "CHANGES_REQUESTED"), "b2")}, "❌ Requested changes outstanding"},
+		{"older commit", []review{on(reviewBy("alice", "CHANGES_REQUESTED"), "a1")}, "🔁 Changes addressed, re-review needed"},
+		{"dismissed", []review{on(reviewBy("alice", "CHANGES_REQUESTED"), "b2"), reviewBy("alice", "DISMISSED")}, "🔁 Changes addressed, re-review needed"},
+		{"approved after", []review{on(reviewBy("alice", "CHANGES_REQUESTED"), "a1"), on(reviewBy("alice", "APPROVED"), "b2")}, ""},
+		{"one still outstanding", []review{on(reviewBy("alice", "CHANGES_REQUESTED"), "a1"), on(reviewBy("bob", "CHANGES_REQUESTED"), "b2")}, "❌ Requested changes outstanding"},
+	} {
+		lines := notifier{ShowChanges: true}.details(pr, prDetails{Reviews: tc.reviews})
+		if tc.line == "" && len(lines) != 0 {
+			t.Errorf("%s: details = %q, want none", tc.name, lines)
+		}
+		if tc.line != "" && (len(lines) != 1 || lines[0] != tc.line) {
+			t.Errorf("%s: details = %q, want %q", tc.name, lines, tc.line)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package pulltabs
+
+import (
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// channelLimiter keeps a token bucket for each Slack channel, so a burst of
+// posts to one channel does not hold up another. Buckets are kept in memory,
+// so each instance limits its own posts.
+type channelLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+var channelLimits = &channelLimiter{buckets: map[string]*bucket{}}
+
+// reserve takes a token from the channel's bucket, which refills at rate per
+// second up to burst tokens. It returns how long to wait before posting.
+func (l *channelLimiter) reserve(channel string, rate float64, burst int, now time.Time) time.Duration {
+	if burst < 1 {
+		burst = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[channel]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[channel] = b
+	}
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		b.last = now
+	}
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// throttle waits until a post to the Slack channel is within ChannelRate.
+func (s notifier) throttle(channel string) {
+	if s.ChannelRate <= 0 {
+		return
+	}
+	time.Sleep(channelLimits.reserve(channel, s.ChannelRate, s.ChannelBurst, s.now()))
+}
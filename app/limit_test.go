@@ -0,0 +1,34 @@
+package pulltabs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChannelLimiter(t *testing.T) {
+	l := &channelLimiter{buckets: map[string]*bucket{}}
+	now := time.Date(2026, time.October, 14, 9, 0, 0, 0, time.UTC)
+
+	// A burst of two posts to #reviews goes out at once, then one a second.
+	for i := 0; i < 2; i++ {
+		if wait := l.reserve("#reviews", 1, 2, now); wait != 0 {
+			t.Errorf("post %d to #reviews waits %s, want none", i+1, wait)
+		}
+	}
+	if wait := l.reserve("#reviews", 1, 2, now); wait != time.Second {
+		t.Errorf("third post to #reviews waits %s, want 1s", wait)
+	}
+	if wait := l.reserve("#reviews", 1, 2, now); wait != 2*time.Second {
+		t.Errorf("fourth post to #reviews waits %s, want 2s", wait)
+	}
+
+	// Another channel has its own bucket.
+	if wait := l.reserve("#releases", 1, 2, now); wait != 0 {
+		t.Errorf("post to #releases waits %s, want none", wait)
+	}
+
+	// Tokens refill over time.
+	if wait := l.reserve("#reviews", 1, 2, now.Add(5*time.Second)); wait != 0 {
+		t.Errorf("post to #reviews after 5s waits %s, want none", wait)
+	}
+}
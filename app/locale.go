@@ -0,0 +1,106 @@
+package pulltabs
+
+// locales are the built-in translations of message text, by Locale and then
+// by message key. Keys missing from a locale fall back to English.
+var locales = map[string]map[string]string{
+	"en": {
+		"message":           "A Pull Request requires review",
+		"by":                "by %s",
+		"responder":         "First responder: %s",
+		"workload":          "%s has %d open review requests",
+		"due in":            "Review due in %s",
+		"overdue":           "Review overdue by %s",
+		"automerge":         "🤖 Auto-merge enabled (%s)",
+		"changes":           "❌ Requested changes outstanding",
+		"addressed":         "🔁 Changes addressed, re-review needed",
+		"title":             "⚠️ non-conventional title",
+		"reviews":           "%d of %d reviews complete",
+		"due":               "%s due %s",
+		"comment":           "1 comment",
+		"comments":          "%d comments",
+		"activity":          "Last activity %s ago",
+		"project":           "Project: %s",
+		"tasks":             "%d/%d tasks done",
+		"closes":            "Closes %s",
+		"review":            "Request changes",
+		"reviewers":         "Reviewers",
+		"label":             "Label",
+		"cc":                "cc %s",
+		"approved":          "✅ Approved",
+		"changes requested": "❌ Changes requested",
+		"review required":   "👀 Review required",
+		"reviewed changes":  "%s requested changes",
+		"reviewed approved": "%s approved",
+		"commented":         "*%s* commented: %s\n<%s|View comment>",
+		"merged":            "Merged after %s",
+		"closed":            "Closed after %s",
+		"approval":          "with 1 approval",
+		"approvals":         "with %d approvals",
+		"queued":            "%s in review queue",
+		"anchor":            "*%s* pull requests for %s",
+		"digest":            "Pull requests waiting for review",
+		"empty":             "The review queue is empty :tada:",
+		"stats":             "*Weekly review stats*",
+		"stats labeled":     "Pull requests labeled: %d",
+		"stats average":     "Average time to review: %s",
+		"stats top":         "Top reviewers: %s",
+	},
+	"es": {
+		"message":           "Un Pull Request requiere revisión",
+		"by":                "por %s",
+		"responder":         "Primer revisor: %s",
+		"workload":          "%s tiene %d revisiones pendientes",
+		"due in":            "La revisión vence en %s",
+		"overdue":           "Revisión vencida hace %s",
+		"automerge":         "🤖 Fusión automática activada (%s)",
+		"changes":           "❌ Cambios solicitados pendientes",
+		"addressed":         "🔁 Cambios aplicados, se necesita otra revisión",
+		"title":             "⚠️ título no convencional",
+		"reviews":           "%d de %d revisiones completadas",
+		"due":               "%s vence el %s",
+		"comment":           "1 comentario",
+		"comments":          "%d comentarios",
+		"activity":          "Última actividad hace %s",
+		"project":           "Proyecto: %s",
+		"tasks":             "%d/%d tareas completadas",
+		"closes":            "Cierra %s",
+		"review":            "Solicitar cambios",
+		"reviewers":         "Revisores",
+		"label":             "Etiqueta",
+		"cc":                "cc %s",
+		"approved":          "✅ Aprobado",
+		"changes requested": "❌ Cambios solicitados",
+		"review required":   "👀 Revisión requerida",
+		"reviewed changes":  "%s solicitó cambios",
+		"reviewed approved": "%s aprobó",
+		"commented":         "*%s* comentó: %s\n<%s|Ver comentario>",
+		"merged":            "Fusionado tras %s",
+		"closed":            "Cerrado tras %s",
+		"approval":          "con 1 aprobación",
+		"approvals":         "con %d aprobaciones",
+		"queued":            "%s en la cola de revisión",
+		"anchor":            "Pull requests de *%s* del %s",
+		"digest":            "Pull requests pendientes de revisión",
+		"empty":             "La cola de revisión está vacía :tada:",
+		"stats":             "*Estadísticas semanales de revisión*",
+		"stats labeled":     "Pull requests etiquetados: %d",
+		"stats average":     "Tiempo medio de revisión: %s",
+		"stats top":         "Principales revisores: %s",
+	},
+}
+
+// tr looks up message text in the configured Locale.
+func (s notifier) tr(key string) string {
+	if text, ok := locales[s.Locale][key]; ok {
+		return text
+	}
+	return locales["en"][key]
+}
+
+// headline is the first line of every message.
+func (s notifier) headline() string {
+	if s.Message != "" {
+		return s.Message
+	}
+	return s.tr("message")
+}
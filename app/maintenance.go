@@ -0,0 +1,79 @@
+package pulltabs
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+// maintenance is the switch set on /admin/maintenance. It is stored so that
+// every instance sees it.
+type maintenance struct {
+	On    bool      `json:"on"`
+	Since time.Time `json:"since"`
+}
+
+func maintenanceKey(c appengine.Context) *datastore.Key {
+	return datastore.NewKey(c, "Maintenance", "maintenance", 0, nil)
+}
+
+func getMaintenance(c appengine.Context) (maintenance, error) {
+	var m maintenance
+	err := datastore.Get(c, maintenanceKey(c), &m)
+	if err == datastore.ErrNoSuchEntity {
+		err = nil
+	}
+	return m, err
+}
+
+// inMaintenance reports whether posts are suppressed, either by the
+// Maintenance option or from /admin/maintenance.
+func (s notifier) inMaintenance(c appengine.Context) bool {
+	if s.Maintenance {
+		return true
+	}
+	m, err := getMaintenance(c)
+	if err != nil {
+		c.Infof("Failed to read maintenance mode for request %s. Error: %s", appengine.RequestID(c), err)
+		return false
+	}
+	return m.On
+}
+
+// adminMaintenance shows maintenance mode on GET and sets it on POST from the
+// "on" form value.
+func (s notifier) adminMaintenance(c appengine.Context, w http.ResponseWriter, req *http.Request) {
+	reqID := appengine.RequestID(c)
+	m, err := getMaintenance(c)
+	if err != nil {
+		c.Infof("Failed to read maintenance mode for request %s. Error: %s", reqID, err)
+		http.Error(w, "Could not read maintenance mode", http.StatusInternalServerError)
+		return
+	}
+	if req.Method == "POST" {
+		on, err := strconv.ParseBool(req.FormValue("on"))
+		if err != nil {
+			http.Error(w, "Invalid value for on", http.StatusBadRequest)
+			return
+		}
+		if on != m.On {
+			m = maintenance{On: on, Since: s.now()}
+			if _, err := datastore.Put(c, maintenanceKey(c), &m); err != nil {
+				c.Infof("Failed to save maintenance mode for request %s. Error: %s", reqID, err)
+				http.Error(w, "Could not save maintenance mode", http.StatusInternalServerError)
+				return
+			}
+		}
+		c.Infof("Maintenance mode set to %t for request %s", on, reqID)
+	}
+	m.On = m.On || s.Maintenance
+	w.Header().Set("CONTENT-TYPE", "application/json; charset=UTF-8")
+	w.Header().Set("CACHE-CONTROL", "max-age=0, no-cache")
+	if err := json.NewEncoder(w).Encode(&m); err != nil {
+		c.Infof("Failed to write maintenance mode for request %s. Error: %s", reqID, err)
+	}
+}
@@ -0,0 +1,57 @@
+package pulltabs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"appengine/aetest"
+)
+
+func TestMaintenance(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	slack := newHook(http.StatusOK)
+	defer slack.Close()
+
+	s := notifier{SlackURL: slack.URL}
+	setMaintenance := func(on string) {
+		req := httptest.NewRequest("POST", "/admin/maintenance", strings.NewReader(url.Values{"on": {on}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.adminMaintenance(c, w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("setting maintenance to %s: status = %d", on, w.Code)
+		}
+	}
+	deliver := func(s notifier) {
+		req := httptest.NewRequest("POST", "/deliver", strings.NewReader(`{"action": "labeled", "pull_request": {"title": "Add the widget"}}`))
+		req.Header.Set("X-AppEngine-QueueName", "default")
+		w := httptest.NewRecorder()
+		s.deliver(c, w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("deliver: status = %d", w.Code)
+		}
+	}
+
+	setMaintenance("true")
+	deliver(s)
+	if posts := slack.posts(); len(posts) != 0 {
+		t.Errorf("posted %d messages during maintenance, want none", len(posts))
+	}
+	setMaintenance("false")
+	deliver(s)
+	if posts := slack.posts(); len(posts) != 1 {
+		t.Errorf("posted %d messages after maintenance, want 1", len(posts))
+	}
+	s.Maintenance = true
+	deliver(s)
+	if posts := slack.posts(); len(posts) != 1 {
+		t.Errorf("posted %d messages in all with the Maintenance option, want 1", len(posts))
+	}
+}
@@ -0,0 +1,118 @@
+package pulltabs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/urlfetch"
+)
+
+const (
+	// outboxBatch is the most queued posts retried by one drain.
+	outboxBatch = 50
+	// outboxMaxAttempts is how many drains retry a post before dropping it.
+	outboxMaxAttempts = 24
+)
+
+// outboxItem is a post that failed every attempt, kept for the outbox cron to
+// retry. Backends are stored by ID so that their secrets stay out of Datastore.
+type outboxItem struct {
+	Backend  string
+	Body     string `datastore:",noindex"`
+	Repo     string
+	Number   int
+	Queued   time.Time
+	Attempts int
+}
+
+// opsAlert tells the OpsBackends about a post that will not be tried again.
+// Alerts are posted once, without retries.
+func (s notifier) opsAlert(c appengine.Context, client *http.Client, b Backend, repo string, number, attempts int, postErr error) {
+	text := fmt.Sprintf("Failed to post %s message for %s#%d after %d attempts\nRequest: %s\nError: %s",
+		b.Type, repo, number, attempts, appengine.RequestID(c), postErr)
+	for _, ops := range s.OpsBackends {
+		body, err := s.textBody(ops, text)
+		if err == nil {
+			_, err = postBody(client, ops, body)
+		}
+		if err != nil {
+			c.Infof("Failed to post %s ops alert for request %s. Error: %s", ops.Type, appengine.RequestID(c), err)
+		}
+	}
+}
+
+// backendID identifies a configured backend without revealing its secrets.
+func backendID(b Backend) string {
+	sum := sha256.Sum256([]byte(b.Type + "\x00" + b.endpoint() + "\x00" + b.Channel + "\x00" + b.Topic))
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+func queuePost(c appengine.Context, pr pullRequestPost, b Backend, body string, now time.Time) error {
+	item := outboxItem{
+		Backend: backendID(b),
+		Body:    body,
+		Repo:    pr.Repository.FullName,
+		Number:  pr.Number,
+		Queued:  now,
+	}
+	_, err := datastore.Put(c, datastore.NewIncompleteKey(c, "Outbox", nil), &item)
+	return err
+}
+
+// drainOutbox retries the oldest queued posts. Posts are removed once sent, or
+// once the backend is no longer configured or outboxMaxAttempts is reached.
+func (s notifier) drainOutbox(c appengine.Context, w http.ResponseWriter, req *http.Request) {
+	reqID := appengine.RequestID(c)
+	if req.Header.Get("X-AppEngine-Cron") == "" {
+		c.Infof("Rejecting outbox request %s not sent by cron", reqID)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if s.inMaintenance(c) {
+		c.Infof("Suppressing outbox request %s during maintenance", reqID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	var items []outboxItem
+	keys, err := datastore.NewQuery("Outbox").Order("Queued").Limit(outboxBatch).GetAll(c, &items)
+	if err != nil {
+		c.Infof("Failed to query outbox for request %s. Error: %s", reqID, err)
+		http.Error(w, "Failed to query outbox", http.StatusInternalServerError)
+		return
+	}
+	backends := map[string]Backend{}
+	for _, b := range s.allBackends() {
+		backends[backendID(b)] = b
+	}
+	client := urlfetch.Client(c)
+	sent := 0
+	for i, item := range items {
+		b, ok := backends[item.Backend]
+		if !ok {
+			c.Infof("Dropping queued post for %s#%d to a removed backend for request %s", item.Repo, item.Number, reqID)
+			datastore.Delete(c, keys[i])
+			continue
+		}
+		if _, _, err := s.post(c, client, b, item.Body); err != nil {
+			item.Attempts++
+			c.Infof("Failed to post queued %s message for %s#%d, attempt %d, for request %s. Error: %s", b.Type, item.Repo, item.Number, item.Attempts, reqID, err)
+			if item.Attempts >= outboxMaxAttempts {
+				s.opsAlert(c, client, b, item.Repo, item.Number, (item.Attempts+1)*(s.Retries+1), err)
+				datastore.Delete(c, keys[i])
+			} else if _, err := datastore.Put(c, keys[i], &item); err != nil {
+				c.Infof("Failed to update queued post for request %s. Error: %s", reqID, err)
+			}
+			continue
+		}
+		sent++
+		if err := datastore.Delete(c, keys[i]); err != nil {
+			c.Infof("Failed to remove sent post for request %s. Error: %s", reqID, err)
+		}
+	}
+	c.Infof("Sent %d of %d queued posts for request %s", sent, len(items), reqID)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,135 @@
+package pulltabs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"appengine"
+	"appengine/aetest"
+	"appengine/datastore"
+)
+
+// queued reads every post in the outbox.
+func queued(t *testing.T, c appengine.Context) []outboxItem {
+	var items []outboxItem
+	if _, err := datastore.NewQuery("Outbox").GetAll(c, &items); err != nil {
+		t.Fatal(err)
+	}
+	return items
+}
+
+func cronRequest() *http.Request {
+	req := httptest.NewRequest("GET", "/admin/outbox", nil)
+	req.Header.Set("X-AppEngine-Cron", "true")
+	return req
+}
+
+func TestQueueFailedPost(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	down := newHook(http.StatusBadGateway)
+	defer down.Close()
+
+	s := notifier{Backends: []Backend{Backend{Type: "slack", URL: down.URL}}, Outbox: true}
+	pr := testEvent(t, `{"action": "labeled", "number": 7, "repository": {"full_name": "octo/repo"}}`)
+	s.notify(c, pr, "")
+	items := queued(t, c)
+	if len(items) != 1 {
+		t.Fatalf("queued %d posts, want 1", len(items))
+	}
+	if items[0].Repo != "octo/repo" || items[0].Number != 7 || items[0].Body != down.posts()[0] {
+		t.Errorf("queued %s#%d %q, want the failed octo/repo#7 post", items[0].Repo, items[0].Number, items[0].Body)
+	}
+	if items[0].Backend != backendID(s.Backends[0]) {
+		t.Errorf("queued for backend %s, want %s", items[0].Backend, backendID(s.Backends[0]))
+	}
+
+	s.Outbox = false
+	s.notify(c, pr, "")
+	if n := len(queued(t, c)); n != 1 {
+		t.Errorf("queued %d posts without Outbox, want 1", n)
+	}
+}
+
+func TestDrainOutbox(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	up := newHook(http.StatusOK)
+	defer up.Close()
+	down := newHook(http.StatusBadGateway)
+	defer down.Close()
+
+	upBackend := Backend{Type: "slack", URL: up.URL}
+	downBackend := Backend{Type: "slack", URL: down.URL}
+	removed := Backend{Type: "slack", URL: "https://hooks.slack.com/services/T0/B0/removed"}
+	pr := testEvent(t, `{"number": 7, "repository": {"full_name": "octo/repo"}}`)
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	for _, b := range []Backend{upBackend, downBackend, removed} {
+		if err := queuePost(c, pr, b, `{"text":"queued"}`, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := notifier{Backends: []Backend{upBackend, downBackend}}
+	w := httptest.NewRecorder()
+	s.drainOutbox(c, w, httptest.NewRequest("GET", "/admin/outbox", nil))
+	if w.Code != http.StatusForbidden || len(up.posts()) != 0 {
+		t.Errorf("drain outside cron = %d after %d posts, want 403 and none", w.Code, len(up.posts()))
+	}
+
+	w = httptest.NewRecorder()
+	s.drainOutbox(c, w, cronRequest())
+	if w.Code != http.StatusOK {
+		t.Errorf("drain = %d, want 200", w.Code)
+	}
+	if posts := up.posts(); len(posts) != 1 || posts[0] != `{"text":"queued"}` {
+		t.Errorf("reposted %q, want the queued message", posts)
+	}
+	items := queued(t, c)
+	if len(items) != 1 || items[0].Backend != backendID(downBackend) || items[0].Attempts != 1 {
+		t.Errorf("outbox = %+v, want only the failed post after one attempt", items)
+	}
+}
+
+func TestOpsAlert(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	up := newHook(http.StatusOK)
+	defer up.Close()
+	down := newHook(http.StatusBadGateway)
+	defer down.Close()
+	ops := newHook(http.StatusOK)
+	defer ops.Close()
+
+	pr := testEvent(t, `{"action": "labeled", "number": 7, "repository": {"full_name": "octo/repo"}}`)
+	opsBackends := []Backend{Backend{Type: "slack", URL: ops.URL}}
+	(notifier{Backends: []Backend{Backend{Type: "slack", URL: up.URL}}, OpsBackends: opsBackends, Retries: 1}).notify(c, pr, "")
+	if alerts := ops.posts(); len(alerts) != 0 {
+		t.Errorf("alerted %q after a successful post, want none", alerts)
+	}
+	(notifier{Backends: []Backend{Backend{Type: "slack", URL: down.URL}}, OpsBackends: opsBackends, Outbox: true}).notify(c, pr, "")
+	if alerts := ops.posts(); len(alerts) != 0 {
+		t.Errorf("alerted %q for a queued post, want none", alerts)
+	}
+
+	(notifier{Backends: []Backend{Backend{Type: "slack", URL: down.URL}}, OpsBackends: opsBackends, Retries: 1}).notify(c, pr, "")
+	alerts := ops.posts()
+	if len(down.posts()) != 3 || len(alerts) != 1 {
+		t.Fatalf("alerted %d times after %d posts, want once after every retry failed", len(alerts), len(down.posts()))
+	}
+	if !strings.Contains(alerts[0], "Failed to post slack message for octo/repo#7 after 2 attempts") {
+		t.Errorf("alert = %s, want the failed post", alerts[0])
+	}
+}
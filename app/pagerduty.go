@@ -0,0 +1,88 @@
+package pulltabs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"appengine"
+	"appengine/urlfetch"
+)
+
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+type pagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary  string `json:"summary"`
+	Source   string `json:"source"`
+	Severity string `json:"severity"`
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key"`
+	Payload     pagerDutyPayload `json:"payload"`
+	Links       []pagerDutyLink  `json:"links,omitempty"`
+}
+
+// pagerDutyDedupKey identifies the incident for a pull request and label so
+// repeated labeling of the same pull request does not open a second incident.
+func pagerDutyDedupKey(pr pullRequestPost) string {
+	return fmt.Sprintf("pulltabs/%s/%d/%s", pr.Repository.FullName, pr.Number, pr.Label.Name)
+}
+
+func (s notifier) pagerDutyOutput(pr pullRequestPost, routingKey string) ([]byte, error) {
+	source := pr.Repository.FullName
+	if source == "" {
+		source = "pulltabs"
+	}
+	e := pagerDutyEvent{
+		RoutingKey:  routingKey,
+		EventAction: "trigger",
+		DedupKey:    pagerDutyDedupKey(pr),
+		Payload: pagerDutyPayload{
+			Summary:  fmt.Sprintf("%s: %s", pr.Label.Name, pr.PullRequest.Title),
+			Source:   source,
+			Severity: "critical",
+		},
+		Links: []pagerDutyLink{
+			pagerDutyLink{
+				Href: pr.PullRequest.HTMLURL,
+				Text: pr.PullRequest.Title,
+			},
+		},
+	}
+	return json.Marshal(&e)
+}
+
+func (s notifier) triggerPagerDuty(c appengine.Context, client *http.Client, pr pullRequestPost, routingKey string) {
+	reqID := appengine.RequestID(c)
+	c.Infof("Triggering PagerDuty event for request %s", reqID)
+	b, err := s.pagerDutyOutput(pr, routingKey)
+	if err != nil {
+		c.Infof("Failed to create PagerDuty event for request %s", reqID)
+		return
+	}
+	r, err := client.Post(pagerDutyEventsURL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		c.Infof("Failed to trigger PagerDuty event for request %s. Error: %s", reqID, err)
+		return
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusAccepted {
+		c.Errorf("Failed to trigger PagerDuty event for request %s. Status: %s", reqID, r.Status)
+	}
+}
+
+// page triggers PagerDuty for a label with a routing key in PagerDutyKeys.
+func (s notifier) page(c appengine.Context, pr pullRequestPost) {
+	if key, ok := s.PagerDutyKeys[pr.Label.Name]; ok {
+		s.triggerPagerDuty(c, urlfetch.Client(c), pr, key)
+	}
+}
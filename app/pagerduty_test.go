@@ -0,0 +1,133 @@
+package pulltabs
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"appengine/aetest"
+)
+
+func TestPagerDutyOutput(t *testing.T) {
+	var pr pullRequestPost
+	pr.Action = "labeled"
+	pr.Number = 42
+	pr.Label.Name = "hotfix"
+	pr.Repository.FullName = "octo/repo"
+	pr.PullRequest.Title = "Fix the outage"
+	pr.PullRequest.HTMLURL = "https://github.com/octo/repo/pull/42"
+
+	b, err := notifier{}.pagerDutyOutput(pr, "routing-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e pagerDutyEvent
+	if err := json.Unmarshal(b, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.RoutingKey != "routing-key" {
+		t.Errorf("routing_key = %q, want %q", e.RoutingKey, "routing-key")
+	}
+	if e.EventAction != "trigger" {
+		t.Errorf("event_action = %q, want %q", e.EventAction, "trigger")
+	}
+	if want := "pulltabs/octo/repo/42/hotfix"; e.DedupKey != want {
+		t.Errorf("dedup_key = %q, want %q", e.DedupKey, want)
+	}
+	if want := "hotfix: Fix the outage"; e.Payload.Summary != want {
+		t.Errorf("summary = %q, want %q", e.Payload.Summary, want)
+	}
+	if e.Payload.Source != "octo/repo" || e.Payload.Severity != "critical" {
+		t.Errorf("source, severity = %q, %q, want %q, %q", e.Payload.Source, e.Payload.Severity, "octo/repo", "critical")
+	}
+	if len(e.Links) != 1 || e.Links[0].Href != pr.PullRequest.HTMLURL {
+		t.Errorf("links = %+v, want the pull request", e.Links)
+	}
+}
+
+func TestPagerDutyDedupKey(t *testing.T) {
+	var pr pullRequestPost
+	pr.Number = 7
+	pr.Label.Name = "hotfix"
+	pr.Repository.FullName = "octo/repo"
+	first := pagerDutyDedupKey(pr)
+	if again := pagerDutyDedupKey(pr); again != first {
+		t.Errorf("relabeling gave dedup key %q, want %q", again, first)
+	}
+	pr.Number = 8
+	if other := pagerDutyDedupKey(pr); other == first {
+		t.Errorf("another pull request shares dedup key %q", other)
+	}
+	pr.Number = 7
+	pr.Label.Name = "security"
+	if other := pagerDutyDedupKey(pr); other == first {
+		t.Errorf("another label shares dedup key %q", other)
+	}
+}
+
+func TestTriggerPagerDuty(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	pr := testEvent(t, `{"action": "labeled", "number": 42, "label": {"name": "hotfix"}, "repository": {"full_name": "octo/repo"}}`)
+	for status, failed := range map[int]bool{
+		http.StatusAccepted:            false,
+		http.StatusBadRequest:          true,
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+	} {
+		client, st := stubClient(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		})
+		lc := &logContext{Context: c}
+		notifier{}.triggerPagerDuty(lc, client, pr, "routing-key")
+		if len(st.requests) != 1 || st.requests[0].URL.String() != pagerDutyEventsURL {
+			t.Errorf("%d: requested %v, want one event", status, st.requests)
+		}
+		if got := len(lc.errors) > 0; got != failed {
+			t.Errorf("%d: logged errors %q, want failure %t", status, lc.errors, failed)
+		}
+	}
+}
+
+func TestPagerDutyGates(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := notifier{
+		Label:         "awaiting review",
+		PagerDutyKeys: map[string]string{"hotfix": "routing-key", "hotfix-quiet": "routing-key"},
+		SkipPrefixes:  []string{"WIP"},
+		SilentLabels:  []string{"hotfix-quiet"},
+		MinReviewers:  1,
+	}
+	for _, tc := range []struct {
+		name  string
+		body  string
+		paged bool
+	}{
+		{"hotfix", `{"action": "labeled", "label": {"name": "hotfix"}, "pull_request": {"state": "open", "title": "Fix the outage", "requested_reviewers": [{"login": "alice"}]}}`, true},
+		{"skipped title", `{"action": "labeled", "label": {"name": "hotfix"}, "pull_request": {"state": "open", "title": "WIP: fix the outage", "requested_reviewers": [{"login": "alice"}]}}`, false},
+		{"no reviewers", `{"action": "labeled", "label": {"name": "hotfix"}, "pull_request": {"state": "open", "title": "Fix the outage"}}`, false},
+		{"closed", `{"action": "labeled", "label": {"name": "hotfix"}, "pull_request": {"state": "closed", "title": "Fix the outage", "requested_reviewers": [{"login": "alice"}]}}`, false},
+		{"silent label", `{"action": "labeled", "label": {"name": "hotfix-quiet"}, "pull_request": {"state": "open", "title": "Fix the outage", "requested_reviewers": [{"login": "alice"}]}}`, false},
+		{"other label", `{"action": "labeled", "label": {"name": "bug"}, "pull_request": {"state": "open", "title": "Fix the outage", "requested_reviewers": [{"login": "alice"}]}}`, false},
+	} {
+		_, lc := servePayload(c, s, "pull_request", tc.body)
+		if got := lc.logged("Triggering PagerDuty event"); got != tc.paged {
+			t.Errorf("%s: paged = %t, want %t", tc.name, got, tc.paged)
+		}
+	}
+
+	s.ReadyOnly = true
+	_, lc := servePayload(c, s, "pull_request", `{"action": "labeled", "label": {"name": "hotfix"}, "pull_request": {"state": "open", "title": "Fix the outage", "requested_reviewers": [{"login": "alice"}]}}`)
+	if lc.logged("Triggering PagerDuty event") {
+		t.Error("paged for a label in ready for review mode")
+	}
+}
@@ -0,0 +1,83 @@
+package pulltabs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"appengine"
+)
+
+const (
+	pubsubAPIURL = "https://pubsub.googleapis.com/v1/"
+	pubsubScope  = "https://www.googleapis.com/auth/pubsub"
+)
+
+// event is the normalized form of a pull request event published to Pub/Sub.
+type event struct {
+	Action string `json:"action"`
+	Repo   string `json:"repo"`
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	URL    string `json:"url"`
+	State  string `json:"state"`
+	Author string `json:"author"`
+	Label  string `json:"label,omitempty"`
+	Sender string `json:"sender"`
+}
+
+func normalize(pr pullRequestPost) event {
+	return event{
+		Action: pr.Action,
+		Repo:   pr.Repository.FullName,
+		Number: pr.Number,
+		Title:  pr.PullRequest.Title,
+		URL:    pr.PullRequest.HTMLURL,
+		State:  pr.PullRequest.State,
+		Author: pr.PullRequest.User.Login,
+		Label:  pr.Label.Name,
+		Sender: pr.Sender.Login,
+	}
+}
+
+type pubsubMessage struct {
+	Data string `json:"data"`
+}
+
+type pubsubPublish struct {
+	Messages []pubsubMessage `json:"messages"`
+}
+
+// publish sends data as a single message to a Pub/Sub topic, authorized as
+// the App Engine service account.
+func publish(c appengine.Context, client *http.Client, topic string, data []byte) error {
+	token, _, err := appengine.AccessToken(c, pubsubScope)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(&pubsubPublish{
+		Messages: []pubsubMessage{
+			pubsubMessage{Data: base64.StdEncoding.EncodeToString(data)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", pubsubAPIURL+topic+":publish", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	r, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("publish to %s: %s", topic, r.Status)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package pulltabs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"appengine/aetest"
+)
+
+func TestPublish(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	pr := testEvent(t, `{"action": "labeled", "number": 9, "label": {"name": "awaiting review"}, "repository": {"full_name": "octo/repo"}, "sender": {"login": "alice"}, "pull_request": {"title": "Add the widget", "html_url": "https://github.com/octo/repo/pull/9", "state": "open", "user": {"login": "bob"}}}`)
+	data, err := json.Marshal(normalize(pr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, st := stubClient(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"messageIds": ["1"]}`))
+	})
+	if err := publish(c, client, "projects/octo/topics/pulls", data); err != nil {
+		t.Fatal(err)
+	}
+
+	req := st.requests[0]
+	if req.URL.String() != "https://pubsub.googleapis.com/v1/projects/octo/topics/pulls:publish" {
+		t.Errorf("published to %s", req.URL)
+	}
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+		t.Errorf("Authorization = %q, want a bearer token", req.Header.Get("Authorization"))
+	}
+	var p pubsubPublish
+	if err := json.Unmarshal([]byte(st.bodies[0]), &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Messages) != 1 {
+		t.Fatalf("published %d messages, want 1", len(p.Messages))
+	}
+	b, err := base64.StdEncoding.DecodeString(p.Messages[0].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e event
+	if err := json.Unmarshal(b, &e); err != nil {
+		t.Fatal(err)
+	}
+	want := event{
+		Action: "labeled",
+		Repo:   "octo/repo",
+		Number: 9,
+		Title:  "Add the widget",
+		URL:    "https://github.com/octo/repo/pull/9",
+		State:  "open",
+		Author: "bob",
+		Label:  "awaiting review",
+		Sender: "alice",
+	}
+	if e != want {
+		t.Errorf("published %+v, want %+v", e, want)
+	}
+}
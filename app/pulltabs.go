@@ -4,68 +4,659 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
 
 	"appengine"
 	"appengine/urlfetch"
 )
 
+// Backend is a chat service notified about pull requests.
+type Backend struct {
+	Type     string                 // "slack", "slackbot", "discord", "telegram", "webhook" or "pubsub"
+	URL      string                 // Incoming webhook URL
+	Token    string                 // Bot token for "slackbot" and "telegram"
+	Channel  string                 // Channel for "slackbot", chat ID for "telegram"
+	Secret   string                 // Key to sign "webhook" request bodies with
+	Topic    string                 // Pub/Sub topic for "pubsub", as projects/<id>/topics/<name>
+	Template *texttemplate.Template // Request body, replaces the built-in format
+	Topics   Templates              // Request bodies by repository topic, ahead of Template
+}
+
+// Templates are request body templates by name.
+type Templates map[string]*texttemplate.Template
+
+// FileRoute sends pull requests that mostly change files with the given
+// extensions to its own backends.
+type FileRoute struct {
+	Extensions []string // Such as ".ts" and ".tsx"
+	Backends   []Backend
+}
+
+// ActionWindows are durations by pull request action.
+type ActionWindows map[string]time.Duration
+
+// SkipRule matches pull requests opened by automation, such as merge trains.
+// A nil pattern matches anything.
+type SkipRule struct {
+	Author *regexp.Regexp // Login of the pull request author
+	Title  *regexp.Regexp
+}
+
 type notifier struct {
-	Label      string
-	Message    string
-	Secret     string
-	SlackURL   string
-	StatusTmpl *template.Template
+	Label         string
+	Message       string // Headline, from Locale when empty
+	Secret        string
+	SlackURL      string    // Slack webhook used when Backends is empty
+	Backends      []Backend // Destinations for every notification
+	StatusTmpl    *template.Template
+	PagerDutyKeys map[string]string // PagerDuty routing keys by label name
+	PreviewImage  bool              // Show the first image in the pull request body
+	ActiveDays    []time.Weekday    // Days to notify on, every day when empty
+	Location      *time.Location    // Time zone for ActiveDays and dates, UTC when nil
+	MorningHour   int               // Hour deferred notifications are sent, 9 when zero
+	Now           func() time.Time  // Clock, time.Now when nil
+	Dedup         bool              // Drop repeated deliveries using Datastore
+	GitHubToken   string            // Token for GitHub API requests
+	ReviewCounts  bool              // Show how many requested reviews are complete
+	NotFoundBody  string            // Body for unknown paths, empty for none
+	NotFoundType  string            // Content type of NotFoundBody
+	Debug         bool              // Log truncated, redacted request bodies
+	ForkMarker    string            // Note shown on pull requests from forks
+	MinReviewers  int               // Requested reviewers needed to notify
+	ShowBranches  bool              // Show the head and base branch names
+	AdminToken    string            // Bearer token for admin endpoints
+	ReviewerList  bool              // List requested reviewers in a field
+	States        []string          // Pull request states to notify for, open when empty
+	Retries       int               // Extra attempts for each failed post
+	Audit         bool              // Record delivery results in Datastore
+	DigestRepos   []string          // Repositories in the daily digest
+	DigestLabels  []string          // Labels in the daily digest, Label when empty
+	DigestByLabel bool              // Group the daily digest by label
+	DigestEmpty   bool              // Post the digest when no pull requests are open
+	EmptyMessage  string            // Text of an empty digest
+	ShowDecision  bool              // Show the review decision from the GraphQL API
+	SkipPrefixes  []string          // Titles starting with these are not notified
+	LabelFields   bool              // Show each pull request label as a field
+	CloseSummary  bool              // Reply in the thread when a pull request closes
+	ChannelRate   float64           // Posts per second to each Slack channel, unlimited when zero
+	ChannelBurst  int               // Posts to a Slack channel allowed at once
+	AckEvents     []string          // Event types answered with 200 and ignored
+	Reaction      string            // Emoji added to bot messages, such as "eyes"
+	CacheStatus   bool              // Render the status page once per instance, except its "counts" template
+	ShowChecklist bool              // Show how many body checklist items are done
+	Labelers      []string          // Senders whose labels notify, everyone when empty
+	Responders    []string          // Reviewers mentioned in turn on each notification
+	ShowRepoDesc  bool              // Show the repository description
+	RequireCI     bool              // Wait for CI to pass before notifying
+	Urgency       map[string]string // Urgency by label name, "high", "medium" or "low"
+	BodyLength    int               // Characters of the body to show, none when zero
+	AllLabels     []string          // Labels that must all be present, instead of Label
+	ShowAuthor    bool              // Show the author linked to their profile
+	SilentLabels  []string          // Labels recorded in Datastore but never posted
+	ShowDueDate   bool              // Show the milestone due date in Location
+	Conflicts     []Backend         // Destinations for pull requests with merge conflicts
+	RemoveLabel   bool              // Remove the watched label once someone is assigned
+	QRCode        bool              // Show a QR code of the pull request link
+	RecentSize    int               // Payload requests listed by /debug/recent
+	ReadyOnly     bool              // Notify when drafts are ready for review, not on labels
+	DedupWindow   time.Duration     // Skip identical messages for a pull request this long
+	ShowComments  bool              // Show the number of comments
+	Methods       []string          // Methods accepted on /payload, POST when empty
+	ThreadReplies bool              // Post new review comments into Slack threads
+	ReviewUpdates bool              // Post a follow-up when changes are requested or approved
+	ChangesColor  string            // Color of changes requested follow-ups, "danger" when empty
+	ShowActivity  bool              // Show the time since the last activity
+	Maintenance   bool              // Suppress all posts, also set on /admin/maintenance
+	ReviewLink    bool              // Link straight to the review form
+	WeeklyStats   bool              // Post weekly review stats, needs Audit
+	FileRoutes    []FileRoute       // Destinations by the most changed file types
+	DedupWindows  ActionWindows     // DedupWindow by action, such as "labeled"
+	ShowIssues    bool              // Show the issues the pull request closes
+	AuthorBlock   bool              // Show the author above Slack attachments
+	Mentions      bool              // Mention requested reviewers and assignees
+	SlackUsers    map[string]string // Slack user IDs by GitHub login, for mentions
+	UserFallback  string            // Unmapped logins: "drop", "plain" or "lookup", "@login" when empty
+	ShowProject   bool              // Show the project columns of the pull request
+	ThreadLabels  []string          // Labels posted under one daily thread by "slackbot"
+	SlackSecret   string            // Signing secret for Slack events on /slack/events
+	DoneReaction  string            // Reaction that removes the label, not the same as Reaction
+	Locale        string            // Language of messages, "en" or "es", en when empty
+	Workload      bool              // Track open review requests, listed on /admin/workload
+	ShowWorkload  bool              // Show the open review requests of each reviewer, needs Workload
+	SkipRules     []SkipRule        // Pull requests not notified, by author and title
+	ReviewSLA     time.Duration     // Time allowed to review after labeling, shown as a countdown
+	Prefix        string            // Start of every message, such as ":tabs:"
+	DigestChunk   int               // Most pull requests in one digest message, all when zero
+	ShowAutoMerge bool              // Show when the pull request merges once approved
+	RepoCounts    bool              // Count events per repository on the status page
+	Outbox        bool              // Queue posts that failed every retry for the outbox cron
+	CheckOnStart  bool              // Check the backend credentials on instance warmup
+	ShowChanges   bool              // Show whether requested changes were addressed
+	OpsBackends   []Backend         // Alerted when a post fails every retry and the outbox
+	TitlePattern  *regexp.Regexp    // Titles not matching are flagged, such as conventional commits
+}
+
+// urgency is how a message for a label of each urgency level stands out.
+type urgency struct {
+	Color   string // Slack attachment color
+	Discord int    // Discord embed color
+	Emoji   string // Prefix for the message text
+}
+
+var urgencies = map[string]urgency{
+	"high":   urgency{Color: "danger", Discord: 0xa30200, Emoji: "🔴"},
+	"medium": urgency{Color: "warning", Discord: 0xdaa038, Emoji: "🟡"},
+	"low":    urgency{Color: "good", Discord: discordGood, Emoji: "🟢"},
 }
 
+// statusPage holds the status page rendered for CacheStatus, split around its
+// "counts" template, which is rendered for every request.
+var statusPage struct {
+	once sync.Once
+	head []byte
+	tail []byte
+	err  error
+}
+
+// statusCountsMarker stands in for the "counts" template in the cached status
+// page so that the page can be split around it.
+const statusCountsMarker = "@@pulltabs-counts@@"
+
+// qrCodeService renders the QR code for the URL appended to it.
+const qrCodeService = "https://api.qrserver.com/v1/create-qr-code/?size=150x150&data="
+
+// debugBodyLimit is the most bytes of a request body logged in debug mode.
+const debugBodyLimit = 4096
+
 type pullRequestPost struct {
 	Action      string `json:"action"`
 	Number      int    `json:"number"`
 	PullRequest struct {
+		Number  int    `json:"number"`
 		HTMLURL string `json:"html_url"`
+		Body    string `json:"body"`
 		State   string `jsong:"state"`
 		Title   string `json:"title"`
 		User    struct {
-			Login string `json:"login"`
+			Login     string `json:"login"`
+			HTMLURL   string `json:"html_url"`
+			AvatarURL string `json:"avatar_url"`
 		} `json:"user"`
+		RequestedReviewers []struct {
+			Login string `json:"login"`
+		} `json:"requested_reviewers"`
+		Assignees []struct {
+			Login string `json:"login"`
+		} `json:"assignees"`
+		Head struct {
+			Ref  string `json:"ref"`
+			SHA  string `json:"sha"`
+			Repo struct {
+				Fork bool `json:"fork"`
+			} `json:"repo"`
+		} `json:"head"`
+		Base struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+		Labels []struct {
+			Name string `json:"name"`
+		} `json:"labels"`
+		Milestone struct {
+			Title string    `json:"title"`
+			DueOn time.Time `json:"due_on"`
+		} `json:"milestone"`
+		MergeableState string    `json:"mergeable_state"`
+		Comments       *int      `json:"comments"`
+		ReviewComments *int      `json:"review_comments"`
+		Merged         bool      `json:"merged"`
+		CreatedAt      time.Time `json:"created_at"`
+		ClosedAt       time.Time `json:"closed_at"`
+		UpdatedAt      time.Time `json:"updated_at"`
+		AutoMerge      *struct {
+			MergeMethod string `json:"merge_method"`
+		} `json:"auto_merge"`
 	} `json:"pull_request"`
 	Label struct {
 		Name string `json:"name"`
 	} `json:"label"`
+	Repository struct {
+		FullName    string   `json:"full_name"`
+		Description string   `json:"description"`
+		Topics      []string `json:"topics"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+	Raw map[string]interface{} `json:"-"` // The whole payload, for templates
+}
+
+// parsePullRequest decodes a pull_request event, keeping the whole payload in
+// Raw as well.
+func parsePullRequest(body []byte) (pullRequestPost, error) {
+	var pr pullRequestPost
+	if err := json.Unmarshal(body, &pr); err != nil {
+		return pr, err
+	}
+	err := json.Unmarshal(body, &pr.Raw)
+	return pr, err
+}
+
+type reviewPost struct {
+	pullRequestPost
+	Review struct {
+		State   string `json:"state"`
+		HTMLURL string `json:"html_url"`
+		User    struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	} `json:"review"`
+}
+
+type reviewCommentPost struct {
+	Action  string `json:"action"`
+	Comment struct {
+		Body    string `json:"body"`
+		HTMLURL string `json:"html_url"`
+		User    struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	} `json:"comment"`
+	PullRequest struct {
+		Number int `json:"number"`
+	} `json:"pull_request"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+type Field struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short,omitempty"`
 }
 
 type Attachment struct {
-	Fallback  string `json:"fallback,omitempty"`
-	Color     string `json:"color,omitempty"`
-	Pretext   string `json:"pretext,omitempty"`
-	Title     string `json:"title,omitempty"`
-	TitleLink string `json:"title_link,omitempty"`
-	Text      string `json:"text,omitempty"`
+	Fallback   string  `json:"fallback,omitempty"`
+	Color      string  `json:"color,omitempty"`
+	Pretext    string  `json:"pretext,omitempty"`
+	AuthorName string  `json:"author_name,omitempty"`
+	AuthorLink string  `json:"author_link,omitempty"`
+	AuthorIcon string  `json:"author_icon,omitempty"`
+	Title      string  `json:"title,omitempty"`
+	TitleLink  string  `json:"title_link,omitempty"`
+	Text       string  `json:"text,omitempty"`
+	ImageURL   string  `json:"image_url,omitempty"`
+	Fields     []Field `json:"fields,omitempty"`
 }
 
 type slackMessage struct {
+	Channel     string       `json:"channel,omitempty"`
+	ThreadTS    string       `json:"thread_ts,omitempty"`
 	Text        string       `json:"text"`
 	Attachments []Attachment `json:"attachments,omitempty"`
 }
 
-func (s notifier) output(pr pullRequestPost) (string, error) {
+// imagePattern matches Markdown images and HTML img tags. Only one of the two
+// submatches is set for any match.
+var imagePattern = regexp.MustCompile(`!\[[^\]]*\]\(\s*<?([^)\s>]+)>?[^)]*\)|<img\s[^>]*src=["']([^"']+)["']`)
+
+// excerpt cuts a body to at most n runes. It reports whether any was cut.
+func excerpt(body string, n int) (string, bool) {
+	body = strings.TrimSpace(body)
+	runes := []rune(body)
+	if len(runes) <= n {
+		return body, false
+	}
+	return strings.TrimSpace(string(runes[:n])), true
+}
+
+// taskPattern matches Markdown task list items.
+var taskPattern = regexp.MustCompile(`(?m)^\s*[-*+]\s+\[([ xX])\]`)
+
+// checklist counts the checked and total task list items in a body.
+func checklist(body string) (done, total int) {
+	for _, m := range taskPattern.FindAllStringSubmatch(body, -1) {
+		if m[1] != " " {
+			done++
+		}
+		total++
+	}
+	return done, total
+}
+
+// closingPattern matches GitHub's closing keywords, such as "Closes #12" or
+// "fixes owner/repo#34".
+var closingPattern = regexp.MustCompile(`(?i)\b(?:close[sd]?|fix(?:e[sd])?|resolve[sd]?):?\s+([\w.-]+/[\w.-]+)?#(\d+)\b`)
+
+// closedIssues links to each issue a pull request body closes, in order and
+// without repeats.
+func closedIssues(body, repo string) []string {
+	var links []string
+	seen := map[string]bool{}
+	for _, m := range closingPattern.FindAllStringSubmatch(body, -1) {
+		name := "#" + m[2]
+		issueRepo := repo
+		if m[1] != "" {
+			name = m[1] + name
+			issueRepo = m[1]
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		links = append(links, fmt.Sprintf("<https://github.com/%s/issues/%s|%s>", issueRepo, m[2], name))
+	}
+	return links
+}
+
+// firstImageURL returns the first http(s) image referenced in a pull request
+// body, or an empty string when there is none.
+func firstImageURL(body string) string {
+	for _, m := range imagePattern.FindAllStringSubmatch(body, -1) {
+		u := m[1]
+		if u == "" {
+			u = m[2]
+		}
+		if strings.HasPrefix(u, "https://") || strings.HasPrefix(u, "http://") {
+			return u
+		}
+	}
+	return ""
+}
+
+// lastActivity is when a pull request last changed, not counting the event
+// being notified about. It is the head commit time when that is known. Labeling
+// updates updated_at, so updated_at is never used for labeled events.
+func lastActivity(pr pullRequestPost, d prDetails) time.Time {
+	if pr.Action == "labeled" || !d.LastActivity.IsZero() {
+		return d.LastActivity
+	}
+	return pr.PullRequest.UpdatedAt
+}
+
+// mentions lists the requested reviewers and then the assignees, mentioning
+// anyone who is both only once.
+func mentions(pr pullRequestPost) []string {
+	var logins []string
+	seen := map[string]bool{}
+	add := func(login string) {
+		if login != "" && !seen[strings.ToLower(login)] {
+			seen[strings.ToLower(login)] = true
+			logins = append(logins, login)
+		}
+	}
+	for _, r := range pr.PullRequest.RequestedReviewers {
+		add(r.Login)
+	}
+	for _, a := range pr.PullRequest.Assignees {
+		add(a.Login)
+	}
+	return logins
+}
+
+// mention renders a login as a Slack mention when the user is known, and
+// otherwise as UserFallback says. Dropped logins are empty.
+func (s notifier) mention(login string, d prDetails) string {
+	if id, ok := s.SlackUsers[login]; ok {
+		return "<@" + id + ">"
+	}
+	if id, ok := d.SlackUsers[login]; ok {
+		return "<@" + id + ">"
+	}
+	switch s.UserFallback {
+	case "drop":
+		return ""
+	case "plain", "lookup":
+		return login
+	}
+	return "@" + login
+}
+
+// details returns the extra lines of text shown under the pull request title.
+func (s notifier) details(pr pullRequestPost, d prDetails) []string {
+	var lines []string
+	if s.ForkMarker != "" && pr.PullRequest.Head.Repo.Fork {
+		lines = append(lines, s.ForkMarker)
+	}
+	if s.TitlePattern != nil && !s.TitlePattern.MatchString(pr.PullRequest.Title) {
+		lines = append(lines, s.tr("title"))
+	}
+	if s.ShowAuthor && pr.PullRequest.User.Login != "" {
+		profile := pr.PullRequest.User.HTMLURL
+		if profile == "" {
+			profile = "https://github.com/" + pr.PullRequest.User.Login
+		}
+		lines = append(lines, fmt.Sprintf(s.tr("by"), fmt.Sprintf("<%s|%s>", profile, pr.PullRequest.User.Login)))
+	}
+	if s.ShowRepoDesc && pr.Repository.Description != "" {
+		lines = append(lines, slackEscape(pr.Repository.Description))
+	}
+	if d.Responder != "" {
+		name := s.mention(d.Responder, d)
+		if name == "" {
+			name = d.Responder
+		}
+		lines = append(lines, fmt.Sprintf(s.tr("responder"), name))
+	}
+	if s.Mentions {
+		var names []string
+		for _, login := range mentions(pr) {
+			if name := s.mention(login, d); name != "" {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 0 {
+			lines = append(lines, fmt.Sprintf(s.tr("cc"), strings.Join(names, " ")))
+		}
+	}
+	for _, r := range pr.PullRequest.RequestedReviewers {
+		if n, ok := d.Workload[r.Login]; ok {
+			lines = append(lines, fmt.Sprintf(s.tr("workload"), r.Login, n))
+		}
+	}
+	if due, ok := s.reviewDue(pr); ok {
+		if left := due.Sub(s.now()); left > 0 {
+			lines = append(lines, fmt.Sprintf(s.tr("due in"), formatDuration(left)))
+		} else {
+			lines = append(lines, fmt.Sprintf(s.tr("overdue"), formatDuration(-left)))
+		}
+	}
+	if s.ShowBranches {
+		lines = append(lines, fmt.Sprintf("%s → %s", pr.PullRequest.Head.Ref, pr.PullRequest.Base.Ref))
+	}
+	if s.ReviewCounts {
+		done, total := reviewProgress(pr, d.Reviews)
+		lines = append(lines, fmt.Sprintf(s.tr("reviews"), done, total))
+	}
+	if badge := decisionBadges[d.ReviewDecision]; s.ShowDecision && badge != "" {
+		lines = append(lines, s.tr(badge))
+	}
+	if s.ShowChanges {
+		outstanding, addressed := changesStatus(pr, d.Reviews)
+		if outstanding {
+			lines = append(lines, s.tr("changes"))
+		} else if addressed {
+			lines = append(lines, s.tr("addressed"))
+		}
+	}
+	if auto := pr.PullRequest.AutoMerge; s.ShowAutoMerge && auto != nil {
+		lines = append(lines, fmt.Sprintf(s.tr("automerge"), auto.MergeMethod))
+	}
+	if s.BodyLength > 0 && pr.PullRequest.Body != "" {
+		text, cut := excerpt(pr.PullRequest.Body, s.BodyLength)
+		text = slackEscape(text)
+		if cut {
+			text += fmt.Sprintf("… <%s|(more)>", pr.PullRequest.HTMLURL)
+		}
+		lines = append(lines, text)
+	}
+	if due := pr.PullRequest.Milestone.DueOn; s.ShowDueDate && !due.IsZero() {
+		lines = append(lines, fmt.Sprintf(s.tr("due"), slackEscape(pr.PullRequest.Milestone.Title), due.In(s.location()).Format("2006-01-02")))
+	}
+	if s.ShowComments && (pr.PullRequest.Comments != nil || pr.PullRequest.ReviewComments != nil) {
+		n := 0
+		if pr.PullRequest.Comments != nil {
+			n += *pr.PullRequest.Comments
+		}
+		if pr.PullRequest.ReviewComments != nil {
+			n += *pr.PullRequest.ReviewComments
+		}
+		if n == 1 {
+			lines = append(lines, s.tr("comment"))
+		} else {
+			lines = append(lines, fmt.Sprintf(s.tr("comments"), n))
+		}
+	}
+	if at := lastActivity(pr, d); s.ShowActivity && !at.IsZero() {
+		lines = append(lines, fmt.Sprintf(s.tr("activity"), formatDuration(s.now().Sub(at))))
+	}
+	for _, p := range d.Projects {
+		name := p.Project
+		if p.Column != "" {
+			name += " › " + p.Column
+		}
+		lines = append(lines, fmt.Sprintf(s.tr("project"), name))
+	}
+	if s.ShowChecklist {
+		if done, total := checklist(pr.PullRequest.Body); total > 0 {
+			lines = append(lines, fmt.Sprintf(s.tr("tasks"), done, total))
+		}
+	}
+	if s.ShowIssues {
+		if issues := closedIssues(pr.PullRequest.Body, pr.Repository.FullName); len(issues) > 0 {
+			lines = append(lines, fmt.Sprintf(s.tr("closes"), strings.Join(issues, ", ")))
+		}
+	}
+	if s.ReviewLink && pr.PullRequest.HTMLURL != "" {
+		lines = append(lines, fmt.Sprintf("<%s/files#submit-review|%s>", pr.PullRequest.HTMLURL, s.tr("review")))
+	}
+	return lines
+}
+
+// fields returns the attachment fields shown with the pull request.
+func (s notifier) fields(pr pullRequestPost, d prDetails) []Field {
+	var fields []Field
+	if s.ReviewerList && len(pr.PullRequest.RequestedReviewers) > 0 {
+		var reviewers []string
+		for _, r := range pr.PullRequest.RequestedReviewers {
+			reviewers = append(reviewers, "• "+r.Login)
+		}
+		fields = append(fields, Field{Title: s.tr("reviewers"), Value: strings.Join(reviewers, "\n")})
+	}
+	if s.LabelFields {
+		for _, l := range pr.PullRequest.Labels {
+			fields = append(fields, Field{Title: s.tr("label"), Value: l.Name, Short: true})
+		}
+	}
+	return fields
+}
+
+// urgency returns the style for the urgency configured for the event's label.
+func (s notifier) urgency(pr pullRequestPost) (urgency, bool) {
+	u, ok := urgencies[s.Urgency[pr.Label.Name]]
+	return u, ok
+}
+
+// reviewDue is when the ReviewSLA runs out. Labeling a pull request updates
+// it, so updated_at is when it was labeled.
+func (s notifier) reviewDue(pr pullRequestPost) (time.Time, bool) {
+	labeled := pr.PullRequest.UpdatedAt
+	if s.ReviewSLA <= 0 || pr.Action != "labeled" || labeled.IsZero() {
+		return time.Time{}, false
+	}
+	return labeled.Add(s.ReviewSLA), true
+}
+
+// breached reports whether the ReviewSLA has run out.
+func (s notifier) breached(pr pullRequestPost) bool {
+	due, ok := s.reviewDue(pr)
+	return ok && !s.now().Before(due)
+}
+
+// prefixed starts a message with the Prefix.
+func (s notifier) prefixed(text string) string {
+	if s.Prefix == "" {
+		return text
+	}
+	return s.Prefix + " " + text
+}
+
+// text is the message text, prefixed by the urgency emoji when there is one.
+func (s notifier) text(pr pullRequestPost) string {
+	if u, ok := s.urgency(pr); ok {
+		return s.prefixed(u.Emoji + " " + s.headline())
+	}
+	return s.prefixed(s.headline())
+}
+
+func (s notifier) message(pr pullRequestPost, d prDetails) slackMessage {
+	color := "good"
+	if u, ok := s.urgency(pr); ok {
+		color = u.Color
+	}
+	if s.breached(pr) {
+		color = "danger"
+	}
 	m := slackMessage{
-		Text: s.Message,
+		Text: s.text(pr),
 		Attachments: []Attachment{
 			Attachment{
-				Color:     "good",
+				Color:     color,
 				Title:     pr.PullRequest.Title,
 				TitleLink: pr.PullRequest.HTMLURL,
+				Text:      strings.Join(s.details(pr, d), "\n"),
+				Fields:    s.fields(pr, d),
 			},
 		},
 	}
+	m.Attachments[0].ImageURL = s.imageURL(pr)
+	if s.AuthorBlock {
+		m.Attachments[0].AuthorName = pr.PullRequest.User.Login
+		m.Attachments[0].AuthorLink = pr.PullRequest.User.HTMLURL
+		m.Attachments[0].AuthorIcon = pr.PullRequest.User.AvatarURL
+	}
+	return m
+}
+
+// qrCodeURL links to a QR code image that encodes a URL.
+func qrCodeURL(u string) string {
+	return qrCodeService + url.QueryEscape(u)
+}
+
+// imageURL picks the image shown with a pull request. A preview of the body
+// image is preferred over the QR code.
+func (s notifier) imageURL(pr pullRequestPost) string {
+	if s.PreviewImage {
+		if u := firstImageURL(pr.PullRequest.Body); u != "" {
+			return u
+		}
+	}
+	if s.QRCode && pr.PullRequest.HTMLURL != "" {
+		return qrCodeURL(pr.PullRequest.HTMLURL)
+	}
+	return ""
+}
+
+func (s notifier) output(pr pullRequestPost, d prDetails) (string, error) {
+	return encode(s.message(pr, d))
+}
+
+func encode(m slackMessage) (string, error) {
 	var buf []byte
 	b := bytes.NewBuffer(buf)
 	if err := json.NewEncoder(b).Encode(&m); err != nil {
@@ -90,80 +681,797 @@ func (s notifier) validHMAC(req *http.Request, body []byte) bool {
 	return hmac.Equal([]byte(expectedSig), []byte(sig))
 }
 
+// labelMatch reports whether the label added by the event should notify. With
+// AllLabels set, the added label must be one of them and the pull request
+// must carry every one.
+func (s notifier) labelMatch(pr pullRequestPost) bool {
+	if len(s.AllLabels) == 0 {
+		return strings.Contains(pr.Label.Name, s.Label)
+	}
+	present := map[string]bool{}
+	for _, l := range pr.PullRequest.Labels {
+		present[l.Name] = true
+	}
+	added := false
+	for _, l := range s.AllLabels {
+		if !present[l] {
+			return false
+		}
+		added = added || l == pr.Label.Name
+	}
+	return added
+}
+
+func (s notifier) silent(label string) bool {
+	for _, l := range s.SilentLabels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+func (s notifier) acceptedState(state string) bool {
+	if len(s.States) == 0 {
+		return state == "open"
+	}
+	for _, st := range s.States {
+		if st == state {
+			return true
+		}
+	}
+	return false
+}
+
+// supported reports whether events of a type are handled.
+func (s notifier) supported(eventType string) bool {
+	switch eventType {
+	case "ping", "pull_request":
+		return true
+	case "pull_request_review_comment":
+		return s.ThreadReplies
+	case "pull_request_review":
+		return s.ReviewUpdates
+	}
+	return false
+}
+
+// acknowledged reports whether an unsupported event type is expected and
+// should be answered without an error.
+func (s notifier) acknowledged(eventType string) bool {
+	for _, t := range s.AckEvents {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (s notifier) trustedLabeler(login string) bool {
+	if len(s.Labelers) == 0 {
+		return true
+	}
+	for _, l := range s.Labelers {
+		if l == login {
+			return true
+		}
+	}
+	return false
+}
+
+// skipTitle reports whether a title starts with one of SkipPrefixes, ignoring
+// case, such as "WIP:" or "[WIP]".
+func (s notifier) skipTitle(title string) bool {
+	title = strings.ToLower(strings.TrimSpace(title))
+	for _, prefix := range s.SkipPrefixes {
+		if strings.HasPrefix(title, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// automated reports whether a pull request matches one of SkipRules.
+func (s notifier) automated(pr pullRequestPost) bool {
+	for _, r := range s.SkipRules {
+		if r.Author != nil && !r.Author.MatchString(pr.PullRequest.User.Login) {
+			continue
+		}
+		if r.Title != nil && !r.Title.MatchString(pr.PullRequest.Title) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (s notifier) payloadMethod(method string) bool {
+	if len(s.Methods) == 0 {
+		return method == "POST"
+	}
+	for _, m := range s.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// adminPrefixes are the paths of operator endpoints. Requests for them need
+// the AdminToken bearer token.
+var adminPrefixes = []string{"/admin", "/debug/"}
+
+func isAdminPath(path string) bool {
+	for _, prefix := range adminPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorized reports whether the request carries the admin bearer token. Admin
+// endpoints are closed to everyone when no token is configured.
+func (s notifier) authorized(req *http.Request) bool {
+	const prefix = "Bearer "
+	auth := req.Header.Get("Authorization")
+	if s.AdminToken == "" || !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(s.AdminToken)) == 1
+}
+
+// redact prepares a request body for the debug log. Configured secrets are
+// masked and the result is cut to debugBodyLimit bytes. Signature headers are
+// never part of the body, so they are never logged.
+func (s notifier) redact(body []byte) string {
+	out := string(body)
+	secrets := []string{s.Secret, s.SlackURL, s.GitHubToken, s.AdminToken, s.SlackSecret}
+	for _, b := range s.allBackends() {
+		secrets = append(secrets, b.URL, b.Token, b.Secret)
+	}
+	for _, key := range s.PagerDutyKeys {
+		secrets = append(secrets, key)
+	}
+	for _, secret := range secrets {
+		if secret != "" {
+			out = strings.Replace(out, secret, "[REDACTED]", -1)
+		}
+	}
+	if len(out) > debugBodyLimit {
+		out = out[:debugBodyLimit] + "...(truncated)"
+	}
+	return out
+}
+
+// sign returns the X-PullTabs-Signature header for an outbound body, in the
+// same form as GitHub's X-Hub-Signature-256.
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
 func (s notifier) status(c appengine.Context, w http.ResponseWriter, req *http.Request) {
 	ctx := struct {
 		Instance string
 		Label    string
+		Repos    []repoCount
 	}{
 		Instance: appengine.InstanceID(),
 		Label:    s.Label,
 	}
+	if s.RepoCounts {
+		var err error
+		if ctx.Repos, err = repoCounts(c); err != nil {
+			c.Infof("Failed to read event counts for request %s. Error: %s", appengine.RequestID(c), err)
+		}
+	}
 	w.Header().Set("CONTENT-TYPE", "text/html; charset=UTF-8")
 	w.Header().Set("CACHE-CONTROL", "max-age=0, no-cache")
 	if req.Method != "HEAD" {
-		s.StatusTmpl.Execute(w, ctx)
+		if s.CacheStatus {
+			statusPage.once.Do(func() {
+				statusPage.head, statusPage.tail, statusPage.err = s.staticStatus(ctx)
+			})
+			if statusPage.err != nil {
+				c.Infof("Failed to render status page for request %s. Error: %s", appengine.RequestID(c), statusPage.err)
+			}
+			w.Write(statusPage.head)
+			if counts := s.StatusTmpl.Lookup("counts"); counts != nil && statusPage.tail != nil {
+				if err := counts.Execute(w, ctx); err != nil {
+					c.Infof("Failed to render event counts for request %s. Error: %s", appengine.RequestID(c), err)
+				}
+			}
+			w.Write(statusPage.tail)
+		} else {
+			s.StatusTmpl.Execute(w, ctx)
+		}
 	}
 	c.Infof("Successfully served status page for request %s", appengine.RequestID(c))
 }
 
+// staticStatus renders the status page with statusCountsMarker in place of
+// its "counts" template and splits it there. The tail is nil when the page has
+// no counts.
+func (s notifier) staticStatus(ctx interface{}) (head, tail []byte, err error) {
+	static, err := s.StatusTmpl.Clone()
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := static.New("counts").Parse(statusCountsMarker); err != nil {
+		return nil, nil, err
+	}
+	var buf bytes.Buffer
+	if err := static.Execute(&buf, ctx); err != nil {
+		return nil, nil, err
+	}
+	page := buf.Bytes()
+	i := bytes.Index(page, []byte(statusCountsMarker))
+	if i < 0 {
+		return page, nil, nil
+	}
+	return page[:i], page[i+len(statusCountsMarker):], nil
+}
+
+// healthTimeout bounds each backend check made by a deep health check.
+const healthTimeout = 5 * time.Second
+
+// health reports the instance is serving. With deep=1 it also checks that
+// every backend answers an HTTP request, which needs the admin token.
+func (s notifier) health(c appengine.Context, w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("CONTENT-TYPE", "text/plain; charset=UTF-8")
+	w.Header().Set("CACHE-CONTROL", "max-age=0, no-cache")
+	if err := startupError(); err != nil {
+		http.Error(w, "startup validation failed", http.StatusServiceUnavailable)
+		return
+	}
+	if req.URL.Query().Get("deep") == "1" {
+		if !s.authorized(req) {
+			w.Header().Set("WWW-AUTHENTICATE", `Bearer realm="pulltabs"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		client := &http.Client{Transport: &urlfetch.Transport{Context: c, Deadline: healthTimeout}}
+		for _, b := range s.allBackends() {
+			if err := reachable(client, b.endpoint()); err != nil {
+				c.Infof("Health check failed for %s backend. Error: %s", b.Type, err)
+				http.Error(w, fmt.Sprintf("%s unreachable", b.Type), http.StatusServiceUnavailable)
+				return
+			}
+		}
+	}
+	io.WriteString(w, "ok\n")
+}
+
+// reachable makes a HEAD request to a backend. Any response short of a server
+// error means the service is up; webhooks reject HEAD requests themselves.
+func reachable(client *http.Client, u string) error {
+	r, err := client.Head(u)
+	if err != nil {
+		return withoutURL(err)
+	}
+	r.Body.Close()
+	if r.StatusCode >= 500 {
+		return fmt.Errorf("unexpected response %s", r.Status)
+	}
+	return nil
+}
+
 func (s notifier) payload(c appengine.Context, w http.ResponseWriter, req *http.Request) {
 	reqID := appengine.RequestID(c)
+	deliveryID := req.Header.Get("X-GitHub-Delivery")
+	if deliveryID != "" {
+		w.Header().Set("X-GitHub-Delivery-Ack", deliveryID)
+	}
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		http.Error(w, "Could not read request", http.StatusInternalServerError)
 		return
 	}
+	if s.Debug {
+		c.Debugf("Body for request %s: %s", reqID, s.redact(body))
+	}
 	if !s.validHMAC(req, body) {
 		c.Infof("Signature invalid for request %s", reqID)
 		http.Error(w, "Signature invalid", http.StatusUnauthorized)
 		return
 	}
 	eventType := req.Header.Get("X-GitHub-Event")
-	if eventType != "ping" && eventType != "pull_request" {
+	if !s.supported(eventType) {
+		if s.acknowledged(eventType) {
+			c.Infof("Ignoring %s event for request %s", eventType, reqID)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
 		http.Error(w, fmt.Sprintf("Unsupported event type: %s", eventType), http.StatusBadRequest)
 		return
 	}
+	if s.Dedup && deliveryID != "" {
+		first, err := claimDelivery(c, deliveryID, s.now())
+		if err != nil {
+			c.Infof("Failed to record delivery %s for request %s: %s", deliveryID, reqID, err)
+			http.Error(w, "Could not record delivery", http.StatusInternalServerError)
+			return
+		}
+		if !first {
+			c.Infof("Skipping repeated delivery %s for request %s", deliveryID, reqID)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+	if s.inMaintenance(c) {
+		c.Infof("Suppressing %s event for request %s during maintenance", eventType, reqID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 	if eventType == "pull_request" {
-		var pr pullRequestPost
-		if err := json.NewDecoder(bytes.NewReader(body)).Decode(&pr); err != nil {
+		pr, err := parsePullRequest(body)
+		if err != nil {
 			c.Infof("Failed to parse JSON for request %s: %s", reqID, err)
 			http.Error(w, "Failed to parse JSON", http.StatusBadRequest)
 			return
 		}
-		if strings.Contains(pr.Label.Name, s.Label) && pr.PullRequest.State == "open" && pr.Action == "labeled" {
-			go s.postSlackMessage(c, pr)
-		} else {
-			c.Infof("Skipping message Action: %s\tLabel: %s\tState: %s", pr.Action, pr.Label.Name, pr.PullRequest.State)
+		if s.RepoCounts {
+			if err := countEvent(c, pr.Repository.FullName); err != nil {
+				c.Infof("Failed to count event for request %s. Error: %s", reqID, err)
+			}
+		}
+		if s.Workload {
+			if err := trackReviews(c, pr); err != nil {
+				c.Infof("Failed to track review requests for request %s. Error: %s", reqID, err)
+			}
+		}
+		reviewers := len(pr.PullRequest.RequestedReviewers)
+		switch {
+		case s.skipTitle(pr.PullRequest.Title):
+			c.Infof("Skipping message for title: %s", pr.PullRequest.Title)
+		case s.automated(pr):
+			c.Infof("Skipping message for automated pull request by %s: %s", pr.PullRequest.User.Login, pr.PullRequest.Title)
+		case pr.Action == "labeled" && !s.trustedLabeler(pr.Sender.Login):
+			c.Infof("Skipping message for label from untrusted sender: %s", pr.Sender.Login)
+		case pr.Action == "assigned" && s.RemoveLabel:
+			s.removeLabels(c, urlfetch.Client(c), pr)
+		case pr.Action == "closed" && s.CloseSummary:
+			s.summarizeThreads(c, urlfetch.Client(c), pr)
+		case pr.Action == "labeled" && s.silent(pr.Label.Name):
+			if err := recordDelivery(c, deliveryID, pr, "silent", 0, s.now()); err != nil {
+				c.Infof("Failed to record silent label for request %s. Error: %s", reqID, err)
+			}
+		case s.ReadyOnly && pr.Action == "ready_for_review" && s.acceptedState(pr.PullRequest.State) && reviewers >= s.MinReviewers:
+			s.schedule(c, body, deliveryID)
+		case s.ReadyOnly:
+			c.Infof("Skipping message in ready for review mode Action: %s\tState: %s\tReviewers: %d", pr.Action, pr.PullRequest.State, reviewers)
+		case s.labelMatch(pr) && s.acceptedState(pr.PullRequest.State) && pr.Action == "labeled" && reviewers >= s.MinReviewers:
+			s.schedule(c, body, deliveryID)
+			s.page(c, pr)
+		case s.PagerDutyKeys[pr.Label.Name] != "" && s.acceptedState(pr.PullRequest.State) && pr.Action == "labeled" && reviewers >= s.MinReviewers:
+			s.page(c, pr)
+		default:
+			c.Infof("Skipping message Action: %s\tLabel: %s\tState: %s\tReviewers: %d", pr.Action, pr.Label.Name, pr.PullRequest.State, reviewers)
+		}
+	}
+	if eventType == "pull_request_review" {
+		var rp reviewPost
+		if err := json.Unmarshal(body, &rp); err != nil {
+			c.Infof("Failed to parse JSON for request %s: %s", reqID, err)
+			http.Error(w, "Failed to parse JSON", http.StatusBadRequest)
+			return
+		}
+		if rp.Action == "submitted" && (rp.Review.State == "changes_requested" || rp.Review.State == "approved") {
+			s.followUp(c, rp)
+		}
+	}
+	if eventType == "pull_request_review_comment" {
+		var rc reviewCommentPost
+		if err := json.Unmarshal(body, &rc); err != nil {
+			c.Infof("Failed to parse JSON for request %s: %s", reqID, err)
+			http.Error(w, "Failed to parse JSON", http.StatusBadRequest)
+			return
+		}
+		if rc.Action == "created" {
+			s.replyToComment(c, urlfetch.Client(c), rc)
 		}
 	}
 	c.Infof("Successful handling of update for request %s", reqID)
 	w.WriteHeader(http.StatusOK)
 }
 
-func (s notifier) postSlackMessage(c appengine.Context, pr pullRequestPost) {
+func (s notifier) deliver(c appengine.Context, w http.ResponseWriter, req *http.Request) {
 	reqID := appengine.RequestID(c)
-	c.Infof("Posting Slack message for request %s", reqID)
-	client := urlfetch.Client(c)
-	b, err := s.output(pr)
+	if req.Header.Get("X-AppEngine-QueueName") == "" {
+		c.Infof("Rejecting deliver request %s not sent by the task queue", reqID)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		c.Infof("Failed to create message for request %s", reqID)
+		http.Error(w, "Could not read request", http.StatusInternalServerError)
 		return
 	}
-	data := url.Values{}
-	data.Set("payload", b)
-	r, err := client.PostForm(s.SlackURL, data)
+	pr, err := parsePullRequest(body)
 	if err != nil {
-		c.Infof("Failed to post Slack message for request %s. Error: %s", reqID, err)
+		c.Infof("Failed to parse JSON for request %s: %s", reqID, err)
+		http.Error(w, "Failed to parse JSON", http.StatusBadRequest)
+		return
+	}
+	deliveryID := req.Header.Get("X-GitHub-Delivery")
+	if s.inMaintenance(c) {
+		c.Infof("Suppressing delivery %s for request %s during maintenance", deliveryID, reqID)
+		w.WriteHeader(http.StatusOK)
 		return
 	}
+	if s.RequireCI {
+		state, err := s.ciState(urlfetch.Client(c), pr)
+		if err != nil {
+			c.Infof("Failed to fetch CI state for request %s. Error: %s", reqID, err)
+			http.Error(w, "Could not fetch CI state", http.StatusInternalServerError)
+			return
+		}
+		checks, _ := strconv.Atoi(req.Header.Get("X-PullTabs-CI-Checks"))
+		switch {
+		case state == "failure":
+			c.Infof("Skipping message with failed CI for request %s", reqID)
+			w.WriteHeader(http.StatusOK)
+			return
+		case state == "pending" && checks+1 >= ciMaxChecks:
+			c.Infof("Giving up waiting for CI for request %s", reqID)
+			w.WriteHeader(http.StatusOK)
+			return
+		case state == "pending":
+			s.deferNotification(c, body, deliveryID, checks+1, s.now().Add(ciPollInterval))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+	s.notify(c, pr, deliveryID)
+	c.Infof("Successful handling of deferred delivery for request %s", reqID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// endpoint is the URL messages for the backend are sent to.
+func (b Backend) endpoint() string {
+	switch b.Type {
+	case "slackbot":
+		return slackAPIURL + "chat.postMessage"
+	case "pubsub":
+		return pubsubAPIURL + b.Topic + ":publish"
+	case "telegram":
+		return telegramAPIURL + "bot" + b.Token + "/sendMessage"
+	}
+	return b.URL
+}
+
+// bodyTemplate picks the request body template for a pull request, preferring
+// the template of the first repository topic in Topics. It is nil when the
+// built-in format is used.
+func (b Backend) bodyTemplate(pr pullRequestPost) *texttemplate.Template {
+	for _, topic := range pr.Repository.Topics {
+		if tmpl, ok := b.Topics[topic]; ok {
+			return tmpl
+		}
+	}
+	return b.Template
+}
+
+func (s notifier) backends() []Backend {
+	if len(s.Backends) > 0 {
+		return s.Backends
+	}
+	return []Backend{Backend{Type: "slack", URL: s.SlackURL}}
+}
+
+// allBackends lists every configured destination.
+func (s notifier) allBackends() []Backend {
+	all := append([]Backend{}, s.backends()...)
+	for _, r := range s.FileRoutes {
+		all = append(all, r.Backends...)
+	}
+	all = append(all, s.OpsBackends...)
+	return append(all, s.Conflicts...)
+}
+
+// backendsFor picks the destinations for a pull request. Pull requests with
+// merge conflicts go to Conflicts, when set, for the author to rebase.
+// Otherwise the FileRoute matching the most changed files is used.
+func (s notifier) backendsFor(pr pullRequestPost, d prDetails) []Backend {
+	if pr.PullRequest.MergeableState == "dirty" && len(s.Conflicts) > 0 {
+		return s.Conflicts
+	}
+	if r, ok := s.fileRoute(d.Files); ok {
+		return r.Backends
+	}
+	return s.backends()
+}
+
+// fileRoute finds the FileRoute with the most matching files. Files matching
+// no route are not counted, and a tie goes to the route listed first.
+func (s notifier) fileRoute(files []string) (FileRoute, bool) {
+	best, most := -1, 0
+	for i, r := range s.FileRoutes {
+		n := 0
+		for _, f := range files {
+			for _, ext := range r.Extensions {
+				if strings.HasSuffix(f, ext) {
+					n++
+					break
+				}
+			}
+		}
+		if n > most {
+			best, most = i, n
+		}
+	}
+	if best < 0 {
+		return FileRoute{}, false
+	}
+	return s.FileRoutes[best], true
+}
+
+// threadLabel reports whether a label's notifications share a daily thread.
+func (s notifier) threadLabel(label string) bool {
+	for _, l := range s.ThreadLabels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupWindow is how long identical messages for an action are skipped.
+func (s notifier) dedupWindow(action string) time.Duration {
+	if window, ok := s.DedupWindows[action]; ok {
+		return window
+	}
+	return s.DedupWindow
+}
+
+// notify posts a pull request to every backend. A failing backend does not
+// stop the others.
+func (s notifier) notify(c appengine.Context, pr pullRequestPost, deliveryID string) {
+	reqID := appengine.RequestID(c)
+	client := urlfetch.Client(c)
+	d := s.fetchDetails(c, client, pr)
+	backends := s.backendsFor(pr, d)
+	window := s.dedupWindow(pr.Action)
+	posted, mostAttempts := 0, 0
+	for _, b := range backends {
+		bd := d
+		if b.Type == "slackbot" && s.threadLabel(pr.Label.Name) {
+			ts, err := s.labelAnchor(c, client, b, pr.Label.Name)
+			if err != nil {
+				c.Infof("Failed to find the %s thread for request %s. Error: %s", pr.Label.Name, reqID, err)
+			}
+			bd.ThreadTS = ts
+		}
+		body, err := s.render(b, pr, bd)
+		if err != nil {
+			c.Infof("Failed to create %s message for request %s. Error: %s", b.Type, reqID, err)
+			continue
+		}
+		key := contentKey(pr, b, body)
+		if window > 0 && !claimContent(c, key, window) {
+			c.Infof("Skipping duplicate %s message for request %s", b.Type, reqID)
+			posted++
+			continue
+		}
+		c.Infof("Posting %s message for request %s", b.Type, reqID)
+		attempts, res, err := s.post(c, client, b, body)
+		if attempts > mostAttempts {
+			mostAttempts = attempts
+		}
+		if err != nil {
+			c.Infof("Failed to post %s message for request %s after %d attempts. Error: %s", b.Type, reqID, attempts, err)
+			if window > 0 {
+				releaseContent(c, key)
+			}
+			if !s.Outbox {
+				s.opsAlert(c, client, b, pr.Repository.FullName, pr.Number, attempts, err)
+			} else if qerr := queuePost(c, pr, b, body, s.now()); qerr != nil {
+				c.Infof("Failed to queue %s message for request %s. Error: %s", b.Type, reqID, qerr)
+				s.opsAlert(c, client, b, pr.Repository.FullName, pr.Number, attempts, err)
+			}
+			continue
+		}
+		posted++
+		if res.TS != "" {
+			if err := saveThread(c, pr, b.Channel, res, s.now()); err != nil {
+				c.Infof("Failed to save thread for request %s. Error: %s", reqID, err)
+			}
+		}
+		if res.TS != "" && s.Reaction != "" {
+			if err := addReaction(client, b, res, s.Reaction); err != nil {
+				c.Infof("Failed to add reaction for request %s. Error: %s", reqID, err)
+			}
+		}
+	}
+	c.Infof("Posted to %d of %d backends for request %s", posted, len(backends), reqID)
+	if s.Audit {
+		status := deliveryStatus(posted, len(backends))
+		if err := recordDelivery(c, deliveryID, pr, status, mostAttempts, s.now()); err != nil {
+			c.Infof("Failed to record delivery for request %s. Error: %s", reqID, err)
+		}
+	}
+}
+
+// post sends to a backend, trying again up to Retries times on failure. It
+// returns the number of attempts made and the Slack response from send.
+func (s notifier) post(c appengine.Context, client *http.Client, b Backend, body string) (int, slackAPIResponse, error) {
+	for attempts := 1; ; attempts++ {
+		res, err := s.send(c, client, b, body)
+		if err == nil || attempts > s.Retries {
+			return attempts, res, err
+		}
+		time.Sleep(time.Duration(attempts) * time.Second)
+	}
+}
+
+// templateData is the value Backend templates are executed with.
+type templateData struct {
+	Message string
+	Event   pullRequestPost
+	Details []string
+	Fields  []Field
+	Raw     map[string]interface{} // The whole payload, such as {{ .Raw.repository.custom_properties.team }}
+}
+
+// render returns the request body for a backend, using the backend's own
+// template when it has one.
+func (s notifier) render(b Backend, pr pullRequestPost, d prDetails) (string, error) {
+	if tmpl := b.bodyTemplate(pr); tmpl != nil {
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, templateData{
+			Message: s.text(pr),
+			Event:   pr,
+			Details: s.details(pr, d),
+			Fields:  s.fields(pr, d),
+			Raw:     pr.Raw,
+		})
+		return buf.String(), err
+	}
+	switch b.Type {
+	case "discord":
+		out, err := s.discordOutput(pr, d)
+		return string(out), err
+	case "slackbot":
+		m := s.message(pr, d)
+		m.Channel = b.Channel
+		m.ThreadTS = d.ThreadTS
+		return encode(m)
+	case "pubsub":
+		out, err := json.Marshal(normalize(pr))
+		return string(out), err
+	case "telegram":
+		out, err := s.telegramOutput(b, pr, d)
+		return string(out), err
+	}
+	return s.output(pr, d)
+}
+
+// send posts a rendered message to a backend. The Slack response is only set
+// for "slackbot" backends.
+func (s notifier) send(c appengine.Context, client *http.Client, b Backend, body string) (slackAPIResponse, error) {
+	if b.Type == "pubsub" {
+		return slackAPIResponse{}, publish(c, client, b.Topic, []byte(body))
+	}
+	if b.Type == "slackbot" {
+		s.throttle(b.Channel)
+	}
+	return postBody(client, b, body)
+}
+
+// withoutURL drops the request URL from an HTTP client error before it is
+// logged, as backend URLs hold webhook secrets and bot tokens.
+func withoutURL(err error) error {
+	if ue, ok := err.(*url.Error); ok {
+		return ue.Err
+	}
+	return err
+}
+
+// postBody sends an already rendered request body to a backend. The Slack
+// response is only set for "slackbot" backends.
+func postBody(client *http.Client, b Backend, body string) (slackAPIResponse, error) {
+	var r *http.Response
+	var err error
+	switch b.Type {
+	case "slackbot":
+		return slackAPI(client, b.Token, "chat.postMessage", []byte(body))
+	case "slack":
+		data := url.Values{}
+		data.Set("payload", body)
+		r, err = client.PostForm(b.URL, data)
+	case "discord":
+		r, err = client.Post(b.URL, "application/json", strings.NewReader(body))
+	case "telegram":
+		r, err = client.Post(b.endpoint(), "application/json", strings.NewReader(body))
+	case "webhook":
+		var req *http.Request
+		req, err = http.NewRequest("POST", b.URL, strings.NewReader(body))
+		if err != nil {
+			return slackAPIResponse{}, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if b.Secret != "" {
+			req.Header.Set("X-PullTabs-Signature", sign(b.Secret, []byte(body)))
+		}
+		r, err = client.Do(req)
+	default:
+		return slackAPIResponse{}, fmt.Errorf("unknown backend type %q", b.Type)
+	}
+	if err != nil {
+		return slackAPIResponse{}, withoutURL(err)
+	}
 	r.Body.Close()
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		return slackAPIResponse{}, fmt.Errorf("unexpected response %s", r.Status)
+	}
+	return slackAPIResponse{}, nil
 }
 
 func (s notifier) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	c := appengine.NewContext(req)
+	s.serve(appengine.NewContext(req), w, req)
+}
+
+// serve routes a request to its handler, checking the admin token first.
+func (s notifier) serve(c appengine.Context, w http.ResponseWriter, req *http.Request) {
 	c.Infof("Serving request %s", appengine.RequestID(c))
-	if strings.HasPrefix(req.URL.Path, "/payload") && req.Method == "POST" {
-		s.payload(c, w, req)
+	if isAdminPath(req.URL.Path) && !s.authorized(req) {
+		c.Infof("Unauthorized admin request for path: %s", req.URL.Path)
+		w.Header().Set("WWW-AUTHENTICATE", `Bearer realm="pulltabs"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if strings.HasPrefix(req.URL.Path, "/payload") && s.payloadMethod(req.Method) {
+		if s.RecentSize > 0 {
+			s.recordPayload(c, w, req)
+		} else {
+			s.payload(c, w, req)
+		}
+		return
+	}
+	if req.URL.Path == "/slack/events" && req.Method == "POST" {
+		s.slackEvents(c, urlfetch.Client(c), w, req)
+		return
+	}
+	if req.URL.Path == "/deliver" && req.Method == "POST" {
+		s.deliver(c, w, req)
+		return
+	}
+	if req.URL.Path == "/debug/config" && req.Method == "GET" {
+		s.debugConfig(c, w, req)
+		return
+	}
+	if req.URL.Path == "/debug/recent" && req.Method == "GET" {
+		s.debugRecent(c, w, req)
+		return
+	}
+	if req.URL.Path == "/admin/workload" && req.Method == "GET" {
+		s.adminWorkload(c, w, req)
+		return
+	}
+	if req.URL.Path == "/admin/maintenance" && (req.Method == "GET" || req.Method == "POST") {
+		s.adminMaintenance(c, w, req)
+		return
+	}
+	if req.URL.Path == "/_ah/warmup" {
+		s.warmup(c, w, req)
+		return
+	}
+	if req.URL.Path == "/healthz" {
+		s.health(c, w, req)
+		return
+	}
+	if req.URL.Path == "/digest" && req.Method == "GET" {
+		s.digest(c, w, req)
+		return
+	}
+	if req.URL.Path == "/outbox" && req.Method == "GET" {
+		s.drainOutbox(c, w, req)
+		return
+	}
+	if req.URL.Path == "/stats" && req.Method == "GET" {
+		s.stats(c, w, req)
 		return
 	}
 	if req.URL.Path == "/" {
@@ -171,7 +1479,23 @@ func (s notifier) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	c.Infof("No handler for method: %s\tpath: %s", req.Method, req.URL.Path)
+	s.notFound(w, req)
+}
+
+func (s notifier) notFound(w http.ResponseWriter, req *http.Request) {
+	if s.NotFoundBody == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	contentType := s.NotFoundType
+	if contentType == "" {
+		contentType = "text/plain; charset=UTF-8"
+	}
+	w.Header().Set("CONTENT-TYPE", contentType)
 	w.WriteHeader(http.StatusNotFound)
+	if req.Method != "HEAD" {
+		io.WriteString(w, s.NotFoundBody)
+	}
 }
 
 var statusTemplate = `<!DOCTYPE html>
@@ -179,9 +1503,16 @@ var statusTemplate = `<!DOCTYPE html>
 	<body>
 		<h1>Pull Tabs instance {{ .Instance }}</h1>
 		<p>Watching for label: {{ .Label }}</p>
+		{{ template "counts" . }}
 	</body>
 </html>
-`
+{{ define "counts" }}{{ if .Repos }}
+		<table>
+			<tr><th>Repository</th><th>Events</th></tr>
+			{{ range .Repos }}<tr><td>{{ .Repo }}</td><td>{{ .Count }}</td></tr>
+			{{ end }}
+		</table>
+		{{ end }}{{ end }}`
 
 func init() {
 	tmpl, err := template.New("status").Parse(statusTemplate)
@@ -190,7 +1521,6 @@ func init() {
 	}
 	handler := notifier{
 		Label:      "awaiting review",
-		Message:    "A Pull Request requires review",
 		StatusTmpl: tmpl,
 	}
 	http.Handle("/", handler)
@@ -0,0 +1,1313 @@
+package pulltabs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	texttemplate "text/template"
+	"time"
+
+	"appengine"
+	"appengine/aetest"
+)
+
+// testEvent decodes a pull_request webhook payload for a test.
+func testEvent(t *testing.T, body string) pullRequestPost {
+	pr, err := parsePullRequest([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pr
+}
+
+// logContext keeps the debug and info messages logged through it.
+type logContext struct {
+	appengine.Context
+	debug  []string
+	info   []string
+	errors []string
+}
+
+func (c *logContext) Debugf(format string, args ...interface{}) {
+	c.debug = append(c.debug, fmt.Sprintf(format, args...))
+}
+
+func (c *logContext) Infof(format string, args ...interface{}) {
+	c.info = append(c.info, fmt.Sprintf(format, args...))
+}
+
+func (c *logContext) Errorf(format string, args ...interface{}) {
+	c.errors = append(c.errors, fmt.Sprintf(format, args...))
+}
+
+// logged reports whether an info message starting with prefix was logged.
+func (c *logContext) logged(prefix string) bool {
+	for _, m := range c.info {
+		if strings.HasPrefix(m, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// servePayload sends a webhook event to the payload handler.
+func servePayload(c appengine.Context, s notifier, eventType, body string) (*httptest.ResponseRecorder, *logContext) {
+	lc := &logContext{Context: c}
+	req := httptest.NewRequest("POST", "/payload", strings.NewReader(body))
+	req.Header.Set("X-GitHub-Event", eventType)
+	w := httptest.NewRecorder()
+	s.payload(lc, w, req)
+	return w, lc
+}
+
+// hook is a webhook endpoint that keeps the bodies posted to it. Slack
+// webhook payloads are unwrapped from their form.
+type hook struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies []string
+}
+
+func newHook(status int) *hook {
+	h := &hook{}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+			form, _ := url.ParseQuery(string(body))
+			body = []byte(form.Get("payload"))
+		}
+		h.mu.Lock()
+		h.bodies = append(h.bodies, string(body))
+		h.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	return h
+}
+
+func (h *hook) posts() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string{}, h.bodies...)
+}
+
+// stubTransport answers HTTP requests with a handler instead of the network,
+// keeping each request and its body.
+type stubTransport struct {
+	handler  http.HandlerFunc
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func (st *stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+	}
+	st.mu.Lock()
+	st.requests = append(st.requests, req)
+	st.bodies = append(st.bodies, string(body))
+	st.mu.Unlock()
+	req.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+	w := httptest.NewRecorder()
+	st.handler(w, req)
+	return w.Result(), nil
+}
+
+// stubClient returns a client whose requests are answered by handler.
+func stubClient(handler http.HandlerFunc) (*http.Client, *stubTransport) {
+	st := &stubTransport{handler: handler}
+	return &http.Client{Transport: st}, st
+}
+
+func TestFirstImageURL(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"No screenshots here.", ""},
+		{"Before:\n![before](https://example.com/before.png)\nAfter:\n![after](https://example.com/after.png)", "https://example.com/before.png"},
+		{`<img width="300" src="https://example.com/shot.png">`, "https://example.com/shot.png"},
+		{"![local](docs/shot.png) ![remote](<https://example.com/remote.png> \"title\")", "https://example.com/remote.png"},
+	}
+	for _, tt := range tests {
+		if got := firstImageURL(tt.body); got != tt.want {
+			t.Errorf("firstImageURL(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestPreviewImage(t *testing.T) {
+	s := notifier{PreviewImage: true}
+	var pr pullRequestPost
+	pr.PullRequest.Body = "Looks like this: ![screenshot](https://example.com/shot.png)"
+	if got := s.message(pr, prDetails{}).Attachments[0].ImageURL; got != "https://example.com/shot.png" {
+		t.Errorf("image_url = %q, want the screenshot", got)
+	}
+	pr.PullRequest.Body = "No screenshot."
+	if got := s.message(pr, prDetails{}).Attachments[0].ImageURL; got != "" {
+		t.Errorf("image_url = %q, want none", got)
+	}
+}
+
+func TestNotFoundBody(t *testing.T) {
+	s := notifier{NotFoundBody: `{"error": "not found"}`, NotFoundType: "application/json"}
+	w := httptest.NewRecorder()
+	s.notFound(w, httptest.NewRequest("GET", "/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if got := w.Body.String(); got != s.NotFoundBody {
+		t.Errorf("body = %q, want %q", got, s.NotFoundBody)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("content type = %q, want %q", got, "application/json")
+	}
+
+	w = httptest.NewRecorder()
+	s.notFound(w, httptest.NewRequest("HEAD", "/missing", nil))
+	if w.Body.Len() != 0 {
+		t.Errorf("HEAD body = %q, want none", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	notifier{}.notFound(w, httptest.NewRequest("GET", "/missing", nil))
+	if w.Code != http.StatusNotFound || w.Body.Len() != 0 {
+		t.Errorf("unconfigured 404 = %d %q, want an empty 404", w.Code, w.Body.String())
+	}
+}
+
+func TestDebugBody(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	const body = `{"zen": "Keep it logically awesome.", "hook": {"config": {"url": "https://hooks.slack.com/services/T0/B0/secret"}}}`
+	for _, debug := range []bool{false, true} {
+		s := notifier{Debug: debug, SlackURL: "https://hooks.slack.com/services/T0/B0/secret"}
+		w, lc := servePayload(c, s, "ping", body)
+		if w.Code != http.StatusOK {
+			t.Fatalf("debug %t: status = %d, want %d", debug, w.Code, http.StatusOK)
+		}
+		logged := strings.Join(lc.debug, "\n")
+		if !debug {
+			if logged != "" {
+				t.Errorf("logged %q outside debug mode", logged)
+			}
+			continue
+		}
+		if !strings.Contains(logged, "[REDACTED]") || !strings.Contains(logged, "logically awesome") {
+			t.Errorf("logged %q, want the redacted body", logged)
+		}
+		if strings.Contains(logged, s.SlackURL) {
+			t.Errorf("logged %q, which has the Slack webhook", logged)
+		}
+	}
+}
+
+func TestRedactTruncates(t *testing.T) {
+	out := notifier{}.redact([]byte(strings.Repeat("a", debugBodyLimit+10)))
+	if want := strings.Repeat("a", debugBodyLimit) + "...(truncated)"; out != want {
+		t.Errorf("redact kept %d bytes, want %d and a marker", len(out), debugBodyLimit)
+	}
+}
+
+func TestForkMarker(t *testing.T) {
+	s := notifier{ForkMarker: "🍴 From a fork"}
+	fork := testEvent(t, `{"pull_request": {"head": {"repo": {"fork": true}}}}`)
+	if lines := s.details(fork, prDetails{}); len(lines) != 1 || lines[0] != s.ForkMarker {
+		t.Errorf("fork details = %q, want the fork marker", lines)
+	}
+	same := testEvent(t, `{"pull_request": {"head": {"repo": {"fork": false}}}}`)
+	if lines := s.details(same, prDetails{}); len(lines) != 0 {
+		t.Errorf("same repository details = %q, want none", lines)
+	}
+}
+
+func TestMinReviewers(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := notifier{Label: "awaiting review", MinReviewers: 2}
+	tests := []struct {
+		reviewers string
+		notified  bool
+	}{
+		{`[{"login": "alice"}]`, false},
+		{`[{"login": "alice"}, {"login": "bob"}]`, true},
+		{`[{"login": "alice"}, {"login": "bob"}, {"login": "carol"}]`, true},
+	}
+	for _, tt := range tests {
+		body := `{"action": "labeled", "label": {"name": "awaiting review"}, "pull_request": {"state": "open", "requested_reviewers": ` + tt.reviewers + `}}`
+		_, lc := servePayload(c, s, "pull_request", body)
+		if got := lc.logged("Deferred notification"); got != tt.notified {
+			t.Errorf("reviewers %s: notified = %t, want %t", tt.reviewers, got, tt.notified)
+		}
+	}
+}
+
+func TestNotifyBackends(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	slack, discord := newHook(http.StatusOK), newHook(http.StatusNoContent)
+	defer slack.Close()
+	defer discord.Close()
+
+	s := notifier{Backends: []Backend{
+		Backend{Type: "slack", URL: slack.URL},
+		Backend{Type: "discord", URL: discord.URL},
+	}}
+	pr := testEvent(t, `{"action": "labeled", "pull_request": {"title": "Add the widget", "html_url": "https://github.com/octo/repo/pull/1"}}`)
+	s.notify(c, pr, "")
+
+	var m slackMessage
+	if posts := slack.posts(); len(posts) != 1 {
+		t.Fatalf("Slack got %d posts, want 1", len(posts))
+	} else if err := json.Unmarshal([]byte(posts[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Attachments) != 1 || m.Attachments[0].Title != "Add the widget" {
+		t.Errorf("Slack message = %+v, want the pull request title", m)
+	}
+	var dm discordMessage
+	if posts := discord.posts(); len(posts) != 1 {
+		t.Fatalf("Discord got %d posts, want 1", len(posts))
+	} else if err := json.Unmarshal([]byte(posts[0]), &dm); err != nil {
+		t.Fatal(err)
+	}
+	if len(dm.Embeds) != 1 || dm.Embeds[0].Title != "Add the widget" {
+		t.Errorf("Discord message = %+v, want the pull request title", dm)
+	}
+}
+
+func TestShowBranches(t *testing.T) {
+	s := notifier{ShowBranches: true}
+	pr := testEvent(t, `{"pull_request": {"head": {"ref": "feature/widgets"}, "base": {"ref": "main"}}}`)
+	text := s.message(pr, prDetails{}).Attachments[0].Text
+	if !strings.Contains(text, "feature/widgets → main") {
+		t.Errorf("text = %q, want the head and base branches", text)
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	tests := []struct {
+		token string
+		auth  string
+		want  bool
+	}{
+		{"s3cret", "Bearer s3cret", true},
+		{"s3cret", "Bearer wrong", false},
+		{"s3cret", "s3cret", false},
+		{"s3cret", "", false},
+		{"", "Bearer ", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/admin/workload", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		if got := (notifier{AdminToken: tt.token}).authorized(req); got != tt.want {
+			t.Errorf("token %q, Authorization %q: authorized = %t, want %t", tt.token, tt.auth, got, tt.want)
+		}
+	}
+	for path, admin := range map[string]bool{"/admin/workload": true, "/debug/config": true, "/payload": false, "/": false} {
+		if got := isAdminPath(path); got != admin {
+			t.Errorf("isAdminPath(%q) = %t, want %t", path, got, admin)
+		}
+	}
+}
+
+func TestAdminEndpoint(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := notifier{AdminToken: "s3cret"}
+	for _, tt := range []struct {
+		auth   string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer s3cret", http.StatusOK},
+	} {
+		req := httptest.NewRequest("GET", "/admin/workload", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		s.serve(c, w, req)
+		if w.Code != tt.status {
+			t.Errorf("Authorization %q: status = %d, want %d", tt.auth, w.Code, tt.status)
+		}
+		if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != `Bearer realm="pulltabs"` {
+			t.Errorf("Authorization %q: WWW-Authenticate = %q, want the bearer challenge", tt.auth, w.Header().Get("WWW-Authenticate"))
+		}
+		if tt.status == http.StatusOK && strings.TrimSpace(w.Body.String()) != "{}" {
+			t.Errorf("Authorization %q: body = %q, want the empty workload", tt.auth, w.Body.String())
+		}
+	}
+}
+
+func TestReviewerList(t *testing.T) {
+	s := notifier{ReviewerList: true}
+	pr := testEvent(t, `{"pull_request": {"requested_reviewers": [{"login": "alice"}, {"login": "bob"}]}}`)
+	fields := s.fields(pr, prDetails{})
+	want := Field{Title: "Reviewers", Value: "• alice\n• bob"}
+	if len(fields) != 1 || fields[0] != want {
+		t.Errorf("fields = %+v, want %+v", fields, want)
+	}
+	if fields := s.fields(testEvent(t, `{}`), prDetails{}); len(fields) != 0 {
+		t.Errorf("fields without reviewers = %+v, want none", fields)
+	}
+}
+
+func TestAcceptedState(t *testing.T) {
+	s := notifier{States: []string{"open", "closed"}}
+	for state, want := range map[string]bool{"open": true, "closed": true, "draft": false} {
+		if got := s.acceptedState(state); got != want {
+			t.Errorf("acceptedState(%q) = %t, want %t", state, got, want)
+		}
+	}
+	if !(notifier{}).acceptedState("open") || (notifier{}).acceptedState("closed") {
+		t.Error("without States only open pull requests should be accepted")
+	}
+}
+
+func TestRenderPerBackend(t *testing.T) {
+	tmpl := texttemplate.Must(texttemplate.New("body").Parse(`{"text": "{{ .Message }}: {{ .Event.PullRequest.Title }}"}`))
+	s := notifier{}
+	pr := testEvent(t, `{"pull_request": {"title": "Add the widget"}}`)
+	custom, err := s.render(Backend{Type: "webhook", Template: tmpl}, pr, prDetails{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"text": "A Pull Request requires review: Add the widget"}`; custom != want {
+		t.Errorf("templated body = %q, want %q", custom, want)
+	}
+	builtIn, err := s.render(Backend{Type: "slack"}, pr, prDetails{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if builtIn == custom || !strings.Contains(builtIn, `"attachments"`) {
+		t.Errorf("Slack body = %q, want the built-in format", builtIn)
+	}
+}
+
+func TestSkipPrefixes(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := notifier{Label: "awaiting review", SkipPrefixes: []string{"WIP", "[Draft]"}}
+	for title, notified := range map[string]bool{
+		"WIP: add the widget":     false,
+		"[draft] add the widget":  false,
+		"Add the widget":          true,
+		"Add the WIP flag option": true,
+	} {
+		body := fmt.Sprintf(`{"action": "labeled", "label": {"name": "awaiting review"}, "pull_request": {"state": "open", "title": %q}}`, title)
+		_, lc := servePayload(c, s, "pull_request", body)
+		if got := lc.logged("Deferred notification"); got != notified {
+			t.Errorf("%q: notified = %t, want %t", title, got, notified)
+		}
+	}
+}
+
+func TestDeepHealth(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	up := newHook(http.StatusOK)
+	defer up.Close()
+	down := newHook(http.StatusOK)
+	down.Close()
+
+	check := func(s notifier, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/healthz?deep=1", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		w := httptest.NewRecorder()
+		s.health(c, w, req)
+		return w
+	}
+	if w := check(notifier{SlackURL: up.URL, AdminToken: "s3cret"}, "s3cret"); w.Code != http.StatusOK {
+		t.Errorf("reachable Slack: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := check(notifier{SlackURL: down.URL, AdminToken: "s3cret"}, "s3cret"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("unreachable Slack: status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	} else if strings.Contains(w.Body.String(), down.URL) {
+		t.Errorf("body %q has the webhook URL", w.Body.String())
+	}
+	if w := check(notifier{SlackURL: up.URL, AdminToken: "s3cret"}, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("deep check without the admin token: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestLabelFields(t *testing.T) {
+	s := notifier{LabelFields: true}
+	pr := testEvent(t, `{"pull_request": {"labels": [{"name": "awaiting review"}, {"name": "backend"}, {"name": "urgent"}]}}`)
+	fields := s.message(pr, prDetails{}).Attachments[0].Fields
+	want := []Field{
+		Field{Title: "Label", Value: "awaiting review", Short: true},
+		Field{Title: "Label", Value: "backend", Short: true},
+		Field{Title: "Label", Value: "urgent", Short: true},
+	}
+	if len(fields) != len(want) {
+		t.Fatalf("fields = %+v, want %+v", fields, want)
+	}
+	for i := range want {
+		if fields[i] != want[i] {
+			t.Errorf("field %d = %+v, want %+v", i, fields[i], want[i])
+		}
+	}
+}
+
+func TestUnsupportedEvents(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := notifier{AckEvents: []string{"issues", "push"}}
+	if w, _ := servePayload(c, s, "push", `{}`); w.Code != http.StatusOK {
+		t.Errorf("acknowledged event: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	w, _ := servePayload(c, s, "deployment", `{}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected event: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if !strings.Contains(w.Body.String(), "Unsupported event type: deployment") {
+		t.Errorf("unexpected event: body = %q", w.Body.String())
+	}
+}
+
+func TestDeliveryAck(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	req := httptest.NewRequest("POST", "/payload", strings.NewReader(`{"zen": "Design for failure."}`))
+	req.Header.Set("X-GitHub-Event", "ping")
+	req.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+	w := httptest.NewRecorder()
+	notifier{}.payload(c, w, req)
+	if got := w.Header().Get("X-GitHub-Delivery-Ack"); got != "72d3162e-cc78-11e3-81ab-4c9367dc0958" {
+		t.Errorf("X-GitHub-Delivery-Ack = %q, want the delivery ID", got)
+	}
+}
+
+func TestCacheStatus(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	reset := func() { statusPage.once, statusPage.head, statusPage.tail, statusPage.err = sync.Once{}, nil, nil, nil }
+	reset()
+	defer reset()
+
+	renders := 0
+	tmpl := template.Must(template.New("status").Funcs(template.FuncMap{
+		"render": func() int { renders++; return renders },
+	}).Parse(`render {{ render }}{{ template "counts" . }}.{{ define "counts" }}{{ range .Repos }} {{ .Repo }}={{ .Count }}{{ end }}{{ end }}`))
+
+	s := notifier{StatusTmpl: tmpl, CacheStatus: true}
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		s.status(c, w, httptest.NewRequest("GET", "/", nil))
+		if got := w.Body.String(); got != "render 1." {
+			t.Errorf("status page %d = %q, want the cached render", i+1, got)
+		}
+	}
+
+	// Event counts change between requests, so only they are rendered again.
+	s.RepoCounts = true
+	for i := 1; i <= 2; i++ {
+		if err := countEvent(c, "octo/repo"); err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		s.status(c, w, httptest.NewRequest("GET", "/", nil))
+		if want := fmt.Sprintf("render 1 octo/repo=%d.", i); w.Body.String() != want {
+			t.Errorf("status page = %q, want %q", w.Body.String(), want)
+		}
+	}
+}
+
+func TestCacheStatusPage(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	reset := func() { statusPage.once, statusPage.head, statusPage.tail, statusPage.err = sync.Once{}, nil, nil, nil }
+	reset()
+	defer reset()
+
+	cached := notifier{Label: "awaiting review", CacheStatus: true, RepoCounts: true, StatusTmpl: template.Must(template.New("status").Parse(statusTemplate))}
+	uncached := cached
+	uncached.CacheStatus = false
+	uncached.StatusTmpl = template.Must(template.New("status").Parse(statusTemplate))
+	for _, repo := range []string{"", "octo/api", "octo/web"} {
+		if repo != "" {
+			if err := countEvent(c, repo); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want := httptest.NewRecorder()
+		uncached.status(c, want, httptest.NewRequest("GET", "/", nil))
+		got := httptest.NewRecorder()
+		cached.status(c, got, httptest.NewRequest("GET", "/", nil))
+		if got.Body.String() != want.Body.String() {
+			t.Errorf("cached status page = %s, want %s", got.Body.String(), want.Body.String())
+		}
+	}
+}
+
+func TestChecklist(t *testing.T) {
+	s := notifier{ShowChecklist: true}
+	pr := testEvent(t, `{"pull_request": {"body": "Steps:\n- [x] Tests\n* [X] Docs\n- [ ] Changelog\n  + [ ] Release notes\n- [link](https://example.com)"}}`)
+	lines := s.details(pr, prDetails{})
+	if len(lines) != 1 || lines[0] != "2/4 tasks done" {
+		t.Errorf("details = %q, want 2/4 tasks done", lines)
+	}
+	pr = testEvent(t, `{"pull_request": {"body": "No tasks, just a [link](https://example.com)."}}`)
+	if lines := s.details(pr, prDetails{}); len(lines) != 0 {
+		t.Errorf("details without a checklist = %q, want none", lines)
+	}
+}
+
+func TestSignedWebhook(t *testing.T) {
+	client, st := stubClient(func(w http.ResponseWriter, r *http.Request) {})
+	b := Backend{Type: "webhook", URL: "https://example.com/hooks/pulltabs", Secret: "It's a Secret to Everybody"}
+	const body = `{"text": "Hello, World!"}`
+	if _, err := postBody(client, b, body); err != nil {
+		t.Fatal(err)
+	}
+	sig := st.requests[0].Header.Get("X-PullTabs-Signature")
+	mac := hmac.New(sha256.New, []byte(b.Secret))
+	mac.Write([]byte(st.bodies[0]))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); sig != want {
+		t.Errorf("X-PullTabs-Signature = %q, want %q", sig, want)
+	}
+
+	client, st = stubClient(func(w http.ResponseWriter, r *http.Request) {})
+	b.Secret = ""
+	if _, err := postBody(client, b, body); err != nil {
+		t.Fatal(err)
+	}
+	if sig := st.requests[0].Header.Get("X-PullTabs-Signature"); sig != "" {
+		t.Errorf("unsigned webhook has X-PullTabs-Signature %q", sig)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// The example from GitHub's webhook signature documentation.
+	const want = "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	if got := sign("It's a Secret to Everybody", []byte("Hello, World!")); got != want {
+		t.Errorf("sign = %q, want %q", got, want)
+	}
+}
+
+func TestLabelers(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := notifier{Label: "awaiting review", Labelers: []string{"alice"}}
+	for sender, notified := range map[string]bool{"alice": true, "mallory": false} {
+		body := fmt.Sprintf(`{"action": "labeled", "label": {"name": "awaiting review"}, "pull_request": {"state": "open"}, "sender": {"login": %q}}`, sender)
+		_, lc := servePayload(c, s, "pull_request", body)
+		if got := lc.logged("Deferred notification"); got != notified {
+			t.Errorf("labeled by %s: notified = %t, want %t", sender, got, notified)
+		}
+	}
+}
+
+func TestResponderMention(t *testing.T) {
+	s := notifier{SlackUsers: map[string]string{"alice": "U024BE7LH"}}
+	pr := testEvent(t, `{}`)
+	if lines := s.details(pr, prDetails{Responder: "alice"}); len(lines) != 1 || lines[0] != "First responder: <@U024BE7LH>" {
+		t.Errorf("details = %q, want a mention of alice", lines)
+	}
+	s.UserFallback = "drop"
+	if lines := s.details(pr, prDetails{Responder: "bob"}); len(lines) != 1 || lines[0] != "First responder: bob" {
+		t.Errorf("details = %q, want bob by name", lines)
+	}
+}
+
+func TestRepoDescription(t *testing.T) {
+	s := notifier{ShowRepoDesc: true}
+	pr := testEvent(t, `{"repository": {"description": "Widgets for <everyone> & more"}}`)
+	if lines := s.details(pr, prDetails{}); len(lines) != 1 || lines[0] != "Widgets for &lt;everyone&gt; &amp; more" {
+		t.Errorf("details = %q, want the escaped description", lines)
+	}
+	pr = testEvent(t, `{"repository": {"description": ""}}`)
+	if lines := s.details(pr, prDetails{}); len(lines) != 0 {
+		t.Errorf("details without a description = %q, want none", lines)
+	}
+}
+
+func TestUrgency(t *testing.T) {
+	s := notifier{Urgency: map[string]string{"hotfix": "high", "bug": "medium", "chore": "low"}}
+	tests := []struct {
+		label   string
+		color   string
+		discord int
+		emoji   string
+	}{
+		{"hotfix", "danger", 0xa30200, "🔴"},
+		{"bug", "warning", 0xdaa038, "🟡"},
+		{"chore", "good", discordGood, "🟢"},
+		{"awaiting review", "good", discordGood, ""},
+	}
+	for _, tt := range tests {
+		pr := testEvent(t, `{"label": {"name": "`+tt.label+`"}}`)
+		m := s.message(pr, prDetails{})
+		if m.Attachments[0].Color != tt.color {
+			t.Errorf("%s: color = %q, want %q", tt.label, m.Attachments[0].Color, tt.color)
+		}
+		wantText := s.headline()
+		if tt.emoji != "" {
+			wantText = tt.emoji + " " + wantText
+		}
+		if m.Text != wantText {
+			t.Errorf("%s: text = %q, want %q", tt.label, m.Text, wantText)
+		}
+		b, err := s.discordOutput(pr, prDetails{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dm discordMessage
+		if err := json.Unmarshal(b, &dm); err != nil {
+			t.Fatal(err)
+		}
+		if dm.Embeds[0].Color != tt.discord {
+			t.Errorf("%s: Discord color = %#x, want %#x", tt.label, dm.Embeds[0].Color, tt.discord)
+		}
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	tests := []struct {
+		body string
+		n    int
+		want string
+		cut  bool
+	}{
+		{"Short body", 20, "Short body", false},
+		{"  Padded body \n", 20, "Padded body", false},
+		{"Añade el botón", 10, "Añade el b", true},
+		{"日本語のテキスト", 3, "日本語", true},
+		{"🎉🎉🎉", 2, "🎉🎉", true},
+		{"Cut after a space", 4, "Cut", true},
+	}
+	for _, tt := range tests {
+		got, cut := excerpt(tt.body, tt.n)
+		if got != tt.want || cut != tt.cut {
+			t.Errorf("excerpt(%q, %d) = %q, %t, want %q, %t", tt.body, tt.n, got, cut, tt.want, tt.cut)
+		}
+	}
+}
+
+func TestBodyExcerpt(t *testing.T) {
+	s := notifier{BodyLength: 12}
+	pr := testEvent(t, `{"pull_request": {"html_url": "https://github.com/octo/repo/pull/1", "body": "Fixes <@U024BE7LH> and <!channel> pings"}}`)
+	lines := s.details(pr, prDetails{})
+	want := "Fixes &lt;@U024… <https://github.com/octo/repo/pull/1|(more)>"
+	if len(lines) != 1 || lines[0] != want {
+		t.Errorf("details = %q, want %q", lines, want)
+	}
+}
+
+func TestLabelMatchAllLabels(t *testing.T) {
+	s := notifier{AllLabels: []string{"awaiting review", "backend"}}
+	tests := []struct {
+		name  string
+		event string
+		want  bool
+	}{
+		{"all present", `{"label": {"name": "backend"}, "pull_request": {"labels": [{"name": "awaiting review"}, {"name": "backend"}]}}`, true},
+		{"partial", `{"label": {"name": "awaiting review"}, "pull_request": {"labels": [{"name": "awaiting review"}]}}`, false},
+		{"other label added", `{"label": {"name": "docs"}, "pull_request": {"labels": [{"name": "awaiting review"}, {"name": "backend"}, {"name": "docs"}]}}`, false},
+	}
+	for _, tt := range tests {
+		if got := s.labelMatch(testEvent(t, tt.event)); got != tt.want {
+			t.Errorf("%s: labelMatch = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+	single := notifier{Label: "awaiting review"}
+	if !single.labelMatch(testEvent(t, `{"label": {"name": "awaiting review"}}`)) {
+		t.Error("Label alone did not match the added label")
+	}
+}
+
+func TestAuthorLink(t *testing.T) {
+	s := notifier{ShowAuthor: true}
+	pr := testEvent(t, `{"pull_request": {"user": {"login": "octocat", "html_url": "https://github.com/octocat"}}}`)
+	if lines := s.details(pr, prDetails{}); len(lines) != 1 || lines[0] != "by <https://github.com/octocat|octocat>" {
+		t.Errorf("details = %q, want a link to the author", lines)
+	}
+	pr = testEvent(t, `{"pull_request": {"user": {"login": "hubot"}}}`)
+	if lines := s.details(pr, prDetails{}); len(lines) != 1 || lines[0] != "by <https://github.com/hubot|hubot>" {
+		t.Errorf("details without a profile URL = %q, want a link to the author", lines)
+	}
+}
+
+func TestMilestoneDueDate(t *testing.T) {
+	s := notifier{ShowDueDate: true, Location: time.FixedZone("PDT", -7*60*60)}
+	pr := testEvent(t, `{"pull_request": {"milestone": {"title": "v2 <beta>", "due_on": "2026-10-20T03:00:00Z"}}}`)
+	if lines := s.details(pr, prDetails{}); len(lines) != 1 || lines[0] != "v2 &lt;beta&gt; due 2026-10-19" {
+		t.Errorf("details = %q, want the due date in Location", lines)
+	}
+	s.Location = nil
+	pr = testEvent(t, `{"pull_request": {"milestone": {"title": "v2", "due_on": "2026-10-20T03:00:00Z"}}}`)
+	if lines := s.details(pr, prDetails{}); len(lines) != 1 || lines[0] != "v2 due 2026-10-20" {
+		t.Errorf("details = %q, want the due date in UTC", lines)
+	}
+	for _, event := range []string{`{"pull_request": {"milestone": {"title": "Backlog", "due_on": null}}}`, `{"pull_request": {"milestone": null}}`} {
+		if lines := s.details(testEvent(t, event), prDetails{}); len(lines) != 0 {
+			t.Errorf("details without a due date = %q, want none", lines)
+		}
+	}
+}
+
+func TestConflictRouting(t *testing.T) {
+	conflicts := []Backend{Backend{Type: "slack", URL: "https://hooks.slack.com/services/T0/B0/conflicts"}}
+	s := notifier{SlackURL: "https://hooks.slack.com/services/T0/B0/reviews", Conflicts: conflicts}
+	dirty := testEvent(t, `{"pull_request": {"mergeable_state": "dirty"}}`)
+	if got := s.backendsFor(dirty, prDetails{}); len(got) != 1 || got[0].URL != conflicts[0].URL {
+		t.Errorf("dirty pull request goes to %+v, want the conflict channel", got)
+	}
+	clean := testEvent(t, `{"pull_request": {"mergeable_state": "clean"}}`)
+	if got := s.backendsFor(clean, prDetails{}); len(got) != 1 || got[0].URL != s.SlackURL {
+		t.Errorf("clean pull request goes to %+v, want the review channel", got)
+	}
+}
+
+func TestQRCode(t *testing.T) {
+	const link = "https://github.com/octo/repo/pull/7?w=1&x=y"
+	want := "https://api.qrserver.com/v1/create-qr-code/?size=150x150&data=https%3A%2F%2Fgithub.com%2Focto%2Frepo%2Fpull%2F7%3Fw%3D1%26x%3Dy"
+	if got := qrCodeURL(link); got != want {
+		t.Errorf("qrCodeURL = %q, want %q", got, want)
+	}
+	s := notifier{QRCode: true, PreviewImage: true}
+	pr := testEvent(t, `{"pull_request": {"html_url": "`+link+`", "body": "No screenshot"}}`)
+	if got := s.imageURL(pr); got != want {
+		t.Errorf("image without a screenshot = %q, want the QR code", got)
+	}
+	pr.PullRequest.Body = "![screenshot](https://example.com/shot.png)"
+	if got := s.imageURL(pr); got != "https://example.com/shot.png" {
+		t.Errorf("image with a screenshot = %q, want the screenshot", got)
+	}
+}
+
+func TestReadyOnly(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := notifier{Label: "awaiting review", ReadyOnly: true}
+	for action, notified := range map[string]bool{"ready_for_review": true, "labeled": false} {
+		body := `{"action": "` + action + `", "label": {"name": "awaiting review"}, "pull_request": {"state": "open"}}`
+		_, lc := servePayload(c, s, "pull_request", body)
+		if got := lc.logged("Deferred notification"); got != notified {
+			t.Errorf("%s: notified = %t, want %t", action, got, notified)
+		}
+	}
+}
+
+func TestDedupWindow(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	slack := newHook(http.StatusOK)
+	defer slack.Close()
+
+	s := notifier{SlackURL: slack.URL, DedupWindow: time.Hour}
+	pr := testEvent(t, `{"action": "labeled", "number": 1, "repository": {"full_name": "octo/repo"}, "pull_request": {"title": "Add the widget"}}`)
+	s.notify(c, pr, "")
+	s.notify(c, pr, "")
+	if posts := slack.posts(); len(posts) != 1 {
+		t.Errorf("identical messages posted %d times, want once", len(posts))
+	}
+	pr.PullRequest.Title = "Add the widgets"
+	s.notify(c, pr, "")
+	if posts := slack.posts(); len(posts) != 2 {
+		t.Errorf("changed message posted %d times in all, want 2", len(posts))
+	}
+}
+
+func TestCommentCount(t *testing.T) {
+	s := notifier{ShowComments: true}
+	tests := []struct {
+		event string
+		want  []string
+	}{
+		{`{"pull_request": {"comments": 2, "review_comments": 3}}`, []string{"5 comments"}},
+		{`{"pull_request": {"comments": 1, "review_comments": 0}}`, []string{"1 comment"}},
+		{`{"pull_request": {"comments": 0}}`, []string{"0 comments"}},
+		{`{"pull_request": {}}`, nil},
+	}
+	for _, tt := range tests {
+		lines := s.details(testEvent(t, tt.event), prDetails{})
+		if strings.Join(lines, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: details = %q, want %q", tt.event, lines, tt.want)
+		}
+	}
+}
+
+func TestPayloadMethods(t *testing.T) {
+	s := notifier{Methods: []string{"POST", "PUT"}}
+	for method, want := range map[string]bool{"POST": true, "PUT": true, "GET": false, "DELETE": false} {
+		if got := s.payloadMethod(method); got != want {
+			t.Errorf("payloadMethod(%s) = %t, want %t", method, got, want)
+		}
+	}
+	if (notifier{}).payloadMethod("PUT") {
+		t.Error("PUT accepted without Methods")
+	}
+}
+
+func TestLastActivity(t *testing.T) {
+	now := time.Date(2026, time.October, 14, 12, 0, 0, 0, time.UTC)
+	s := notifier{ShowActivity: true, Now: func() time.Time { return now }}
+	labeled := testEvent(t, `{"action": "labeled", "pull_request": {"updated_at": "2026-10-14T12:00:00Z"}}`)
+	tests := []struct {
+		name string
+		head time.Time
+		want string
+	}{
+		{"recent", now.Add(-25 * time.Minute), "Last activity 25m ago"},
+		{"stale", now.Add(-(9*24 + 3) * time.Hour), "Last activity 9d 3h ago"},
+	}
+	for _, tt := range tests {
+		lines := s.details(labeled, prDetails{LastActivity: tt.head})
+		if len(lines) != 1 || lines[0] != tt.want {
+			t.Errorf("%s: details = %q, want %q", tt.name, lines, tt.want)
+		}
+	}
+	// Labeling updates updated_at, so it is not the last activity.
+	if lines := s.details(labeled, prDetails{}); len(lines) != 0 {
+		t.Errorf("details without the head commit time = %q, want none", lines)
+	}
+}
+
+func TestReviewLink(t *testing.T) {
+	s := notifier{ReviewLink: true}
+	pr := testEvent(t, `{"pull_request": {"html_url": "https://github.com/octo/repo/pull/8"}}`)
+	want := "<https://github.com/octo/repo/pull/8/files#submit-review|Request changes>"
+	if lines := s.details(pr, prDetails{}); len(lines) != 1 || lines[0] != want {
+		t.Errorf("details = %q, want %q", lines, want)
+	}
+}
+
+func TestFileRoute(t *testing.T) {
+	goRoute := FileRoute{Extensions: []string{".go"}, Backends: []Backend{Backend{Type: "slack", URL: "https://hooks.slack.com/services/T0/B0/go"}}}
+	tsRoute := FileRoute{Extensions: []string{".ts", ".tsx"}, Backends: []Backend{Backend{Type: "slack", URL: "https://hooks.slack.com/services/T0/B0/ts"}}}
+	s := notifier{SlackURL: "https://hooks.slack.com/services/T0/B0/all", FileRoutes: []FileRoute{goRoute, tsRoute}}
+	tests := []struct {
+		name  string
+		files []string
+		want  string
+	}{
+		{"Go", []string{"main.go", "main_test.go", "web/app.ts", "README.md"}, goRoute.Backends[0].URL},
+		{"TypeScript", []string{"server.go", "web/app.ts", "web/App.tsx", "web/index.ts"}, tsRoute.Backends[0].URL},
+		{"tie", []string{"server.go", "web/app.ts"}, goRoute.Backends[0].URL},
+		{"neither", []string{"README.md", "Makefile"}, s.SlackURL},
+		{"unknown", nil, s.SlackURL},
+	}
+	for _, tt := range tests {
+		got := s.backendsFor(testEvent(t, `{}`), prDetails{Files: tt.files})
+		if len(got) != 1 || got[0].URL != tt.want {
+			t.Errorf("%s: backends = %+v, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDedupWindows(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	slack := newHook(http.StatusOK)
+	defer slack.Close()
+
+	s := notifier{SlackURL: slack.URL, DedupWindow: 10 * time.Minute, DedupWindows: ActionWindows{"labeled": time.Hour, "synchronize": time.Second}}
+	labeled := testEvent(t, `{"action": "labeled", "number": 1, "repository": {"full_name": "octo/repo"}, "pull_request": {"title": "Add the widget"}}`)
+	synchronized := testEvent(t, `{"action": "synchronize", "number": 2, "repository": {"full_name": "octo/repo"}, "pull_request": {"title": "Add the widget"}}`)
+	for _, pr := range []pullRequestPost{labeled, synchronized, labeled, synchronized} {
+		s.notify(c, pr, "")
+	}
+	if posts := slack.posts(); len(posts) != 2 {
+		t.Errorf("posted %d times within both windows, want once per action", len(posts))
+	}
+
+	// Only the synchronize window has run out.
+	time.Sleep(1100 * time.Millisecond)
+	s.notify(c, labeled, "")
+	if posts := slack.posts(); len(posts) != 2 {
+		t.Errorf("labeled repeat posted within its hour window")
+	}
+	s.notify(c, synchronized, "")
+	if posts := slack.posts(); len(posts) != 3 {
+		t.Errorf("synchronize repeat was not posted after its one second window")
+	}
+	if got := s.dedupWindow("ready_for_review"); got != 10*time.Minute {
+		t.Errorf("dedupWindow(%q) = %s, want the DedupWindow default", "ready_for_review", got)
+	}
+}
+
+func TestClosedIssues(t *testing.T) {
+	body := "Closes #12, fixes other/lib#7 and resolves: #34.\nAlso fixed #12 again.\nSee #99, which this does not close."
+	want := []string{
+		"<https://github.com/octo/repo/issues/12|#12>",
+		"<https://github.com/other/lib/issues/7|other/lib#7>",
+		"<https://github.com/octo/repo/issues/34|#34>",
+	}
+	got := closedIssues(body, "octo/repo")
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("closedIssues = %q, want %q", got, want)
+	}
+	if got := closedIssues("Refactors the widget. Related to #5.", "octo/repo"); len(got) != 0 {
+		t.Errorf("closedIssues without closing references = %q, want none", got)
+	}
+	s := notifier{ShowIssues: true}
+	pr := testEvent(t, `{"repository": {"full_name": "octo/repo"}, "pull_request": {"body": "Closes #12"}}`)
+	if lines := s.details(pr, prDetails{}); len(lines) != 1 || lines[0] != "Closes "+want[0] {
+		t.Errorf("details = %q, want the closed issue", lines)
+	}
+}
+
+func TestAuthorBlock(t *testing.T) {
+	s := notifier{AuthorBlock: true}
+	pr := testEvent(t, `{"pull_request": {"user": {"login": "octocat", "html_url": "https://github.com/octocat", "avatar_url": "https://avatars.githubusercontent.com/u/583231"}}}`)
+	out, err := s.output(pr, prDetails{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m struct {
+		Attachments []map[string]interface{} `json:"attachments"`
+	}
+	if err := json.Unmarshal([]byte(out), &m); err != nil {
+		t.Fatal(err)
+	}
+	a := m.Attachments[0]
+	if a["author_name"] != "octocat" || a["author_link"] != "https://github.com/octocat" || a["author_icon"] != "https://avatars.githubusercontent.com/u/583231" {
+		t.Errorf("attachment = %v, want the author block", a)
+	}
+	out, err = notifier{}.output(pr, prDetails{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "author_") {
+		t.Errorf("output without AuthorBlock = %s, want no author fields", out)
+	}
+}
+
+func TestMentions(t *testing.T) {
+	pr := testEvent(t, `{"pull_request": {"requested_reviewers": [{"login": "alice"}, {"login": "Bob"}], "assignees": [{"login": "bob"}, {"login": "carol"}, {"login": "alice"}]}}`)
+	if got := strings.Join(mentions(pr), " "); got != "alice Bob carol" {
+		t.Errorf("mentions = %q, want each person once", got)
+	}
+	s := notifier{Mentions: true, SlackUsers: map[string]string{"alice": "U024BE7LH"}}
+	if lines := s.details(pr, prDetails{}); len(lines) != 1 || lines[0] != "cc <@U024BE7LH> @Bob @carol" {
+		t.Errorf("details = %q, want a single mention of each", lines)
+	}
+}
+
+func TestLocale(t *testing.T) {
+	pr := testEvent(t, `{"pull_request": {"title": "Add the widget", "body": "- [x] one\n- [ ] two", "user": {"login": "alice"}}}`)
+	for _, tc := range []struct {
+		locale   string
+		headline string
+		details  []string
+	}{
+		{"", "A Pull Request requires review", []string{"by <https://github.com/alice|alice>", "1/2 tasks done"}},
+		{"en", "A Pull Request requires review", []string{"by <https://github.com/alice|alice>", "1/2 tasks done"}},
+		{"es", "Un Pull Request requiere revisión", []string{"por <https://github.com/alice|alice>", "1/2 tareas completadas"}},
+		{"fr", "A Pull Request requires review", []string{"by <https://github.com/alice|alice>", "1/2 tasks done"}},
+	} {
+		s := notifier{Locale: tc.locale, ShowAuthor: true, ShowChecklist: true}
+		if got := s.headline(); got != tc.headline {
+			t.Errorf("%q: headline = %q, want %q", tc.locale, got, tc.headline)
+		}
+		if lines := s.details(pr, prDetails{}); fmt.Sprint(lines) != fmt.Sprint(tc.details) {
+			t.Errorf("%q: details = %q, want %q", tc.locale, lines, tc.details)
+		}
+		body, err := s.render(Backend{Type: "slack"}, pr, prDetails{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(body, tc.headline) {
+			t.Errorf("%q: body = %s, want headline %q", tc.locale, body, tc.headline)
+		}
+	}
+	s := notifier{Locale: "es", Message: "Revisar"}
+	if got := s.headline(); got != "Revisar" {
+		t.Errorf("headline = %q, want Message over the locale", got)
+	}
+
+	// Every part of a Spanish message is translated.
+	s = notifier{Locale: "es", Mentions: true, UserFallback: "plain", ShowDecision: true, ShowChecklist: true, ShowAutoMerge: true, ReviewerList: true, LabelFields: true}
+	pr = testEvent(t, `{"action": "labeled", "label": {"name": "backend"}, "pull_request": {"body": "- [x] one\n- [ ] two", "auto_merge": {"merge_method": "squash"}, "requested_reviewers": [{"login": "alice"}], "labels": [{"name": "backend"}]}}`)
+	m := s.message(pr, prDetails{ReviewDecision: "CHANGES_REQUESTED"})
+	want := []string{"cc alice", "❌ Cambios solicitados", "🤖 Fusión automática activada (squash)", "1/2 tareas completadas"}
+	if m.Text != "Un Pull Request requiere revisión" || m.Attachments[0].Text != strings.Join(want, "\n") {
+		t.Errorf("message = %q, %q, want %q, %q", m.Text, m.Attachments[0].Text, "Un Pull Request requiere revisión", want)
+	}
+	if f := m.Attachments[0].Fields; len(f) != 2 || f[0].Title != "Revisores" || f[1].Title != "Etiqueta" {
+		t.Errorf("fields = %+v, want Spanish titles", f)
+	}
+
+	closed := testEvent(t, `{"action": "closed", "pull_request": {"merged": true, "created_at": "2026-10-12T09:00:00Z", "closed_at": "2026-10-13T12:30:00Z"}}`)
+	digest := []digestGroup{digestGroup{Items: []searchItem{digestItem(1)}}}
+	for _, tt := range []struct {
+		got, want string
+	}{
+		{s.reviewText(reviewEvent(t, "approved")), "alice aprobó"},
+		{s.reviewText(reviewEvent(t, "changes_requested")), "alice solicitó cambios"},
+		{s.closeSummary(closed, 1, 26*time.Hour), "Fusionado tras 1d 3h con 1 aprobación, 1d 2h en la cola de revisión"},
+		{s.closeSummary(closed, 2, 0), "Fusionado tras 1d 3h con 2 aprobaciones"},
+		{s.digestText(digest, slackLink), "Pull requests pendientes de revisión\n\n• <https://github.com/octo/repo/pull/1|Pull request 1>"},
+		{s.emptyMessage(), "La cola de revisión está vacía :tada:"},
+		{s.statsText(reviewStats{Labeled: 2}), "*Estadísticas semanales de revisión*\nPull requests etiquetados: 2"},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("text = %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestSkipRules(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := notifier{Label: "awaiting review", SkipRules: []SkipRule{
+		{Author: regexp.MustCompile(`^merge-train\[bot\]$`)},
+		{Author: regexp.MustCompile(`^release-bot$`), Title: regexp.MustCompile(`^Release v`)},
+	}}
+	for _, tc := range []struct {
+		author, title string
+		notified      bool
+	}{
+		{"merge-train[bot]", "Merge train #42", false},
+		{"release-bot", "Release v1.2.0", false},
+		{"release-bot", "Bump the widget", true},
+		{"alice", "Release v1.2.0", true},
+		{"alice", "Add the widget", true},
+	} {
+		body := fmt.Sprintf(`{"action": "labeled", "label": {"name": "awaiting review"}, "pull_request": {"state": "open", "title": %q, "user": {"login": %q}}}`, tc.title, tc.author)
+		_, lc := servePayload(c, s, "pull_request", body)
+		if got := lc.logged("Deferred notification"); got != tc.notified {
+			t.Errorf("%s %q: notified = %t, want %t", tc.author, tc.title, got, tc.notified)
+		}
+		if skipped := lc.logged("Skipping message for automated"); skipped == tc.notified {
+			t.Errorf("%s %q: skipped as automated = %t, want %t", tc.author, tc.title, skipped, !tc.notified)
+		}
+	}
+}
+
+func TestReviewSLA(t *testing.T) {
+	pr := testEvent(t, `{"action": "labeled", "pull_request": {"updated_at": "2026-10-15T09:00:00Z"}}`)
+	labeled := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		elapsed time.Duration
+		line    string
+		color   string
+	}{
+		{90 * time.Minute, "Review due in 2h 30m", "good"},
+		{4 * time.Hour, "Review overdue by 0m", "danger"},
+		{29 * time.Hour, "Review overdue by 1d 1h", "danger"},
+	} {
+		now := labeled.Add(tc.elapsed)
+		s := notifier{ReviewSLA: 4 * time.Hour, Now: func() time.Time { return now }}
+		if lines := s.details(pr, prDetails{}); len(lines) != 1 || lines[0] != tc.line {
+			t.Errorf("after %s: details = %q, want %q", tc.elapsed, lines, tc.line)
+		}
+		if color := s.message(pr, prDetails{}).Attachments[0].Color; color != tc.color {
+			t.Errorf("after %s: color = %q, want %q", tc.elapsed, color, tc.color)
+		}
+	}
+	s := notifier{ReviewSLA: 4 * time.Hour}
+	opened := testEvent(t, `{"action": "opened", "pull_request": {"updated_at": "2026-10-15T09:00:00Z"}}`)
+	if lines := s.details(opened, prDetails{}); len(lines) != 0 {
+		t.Errorf("details = %q, want no countdown before labeling", lines)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	s := notifier{Prefix: ":tabs:", Urgency: map[string]string{"hotfix": "high"}}
+	for label, want := range map[string]string{
+		"awaiting review": ":tabs: A Pull Request requires review",
+		"hotfix":          ":tabs: 🔴 A Pull Request requires review",
+	} {
+		pr := testEvent(t, `{"label": {"name": "`+label+`"}}`)
+		if got := s.message(pr, prDetails{}).Text; got != want {
+			t.Errorf("%s: text = %q, want %q", label, got, want)
+		}
+	}
+	for typ, want := range map[string]string{
+		"slack":   `{"channel":"#reviews","text":":tabs: 3 pull requests awaiting review"}`,
+		"discord": `{"content":":tabs: 3 pull requests awaiting review"}`,
+	} {
+		body, err := s.textBody(Backend{Type: typ, Channel: "#reviews"}, "3 pull requests awaiting review")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != want {
+			t.Errorf("%s: body = %s, want %s", typ, body, want)
+		}
+	}
+	if got := s.reviewText(reviewEvent(t, "approved")); got != ":tabs: alice approved" {
+		t.Errorf("review text = %q, want the prefix", got)
+	}
+	if got := (notifier{}).text(testEvent(t, `{}`)); got != "A Pull Request requires review" {
+		t.Errorf("text = %q, want no prefix when unset", got)
+	}
+}
+
+func TestAutoMerge(t *testing.T) {
+	enabled := testEvent(t, `{"pull_request": {"auto_merge": {"merge_method": "squash"}}}`)
+	disabled := testEvent(t, `{"pull_request": {"auto_merge": null}}`)
+	s := notifier{ShowAutoMerge: true}
+	if lines := s.details(enabled, prDetails{}); len(lines) != 1 || lines[0] != "🤖 Auto-merge enabled (squash)" {
+		t.Errorf("details = %q, want the auto-merge badge", lines)
+	}
+	if lines := s.details(disabled, prDetails{}); len(lines) != 0 {
+		t.Errorf("details = %q, want none without auto-merge", lines)
+	}
+	if lines := (notifier{}).details(enabled, prDetails{}); len(lines) != 0 {
+		t.Errorf("details = %q, want none when ShowAutoMerge is off", lines)
+	}
+}
+
+func TestRepoCounts(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := notifier{Label: "awaiting review", RepoCounts: true, StatusTmpl: template.Must(template.New("status").Parse(statusTemplate))}
+	for _, repo := range []string{"octo/api", "octo/web", "octo/api"} {
+		servePayload(c, s, "pull_request", `{"action": "opened", "repository": {"full_name": "`+repo+`"}}`)
+	}
+	w := httptest.NewRecorder()
+	s.status(c, w, httptest.NewRequest("GET", "/", nil))
+	for _, row := range []string{
+		"<tr><td>octo/api</td><td>2</td></tr>",
+		"<tr><td>octo/web</td><td>1</td></tr>",
+	} {
+		if !strings.Contains(w.Body.String(), row) {
+			t.Errorf("status page = %s, want row %s", w.Body.String(), row)
+		}
+	}
+}
+
+func TestTopicTemplates(t *testing.T) {
+	b := Backend{
+		Type:     "webhook",
+		Template: texttemplate.Must(texttemplate.New("default").Parse(`default: {{ .Event.PullRequest.Title }}`)),
+		Topics: Templates{
+			"mobile":   texttemplate.Must(texttemplate.New("mobile").Parse(`mobile: {{ .Event.PullRequest.Title }}`)),
+			"frontend": texttemplate.Must(texttemplate.New("frontend").Parse(`frontend: {{ .Event.PullRequest.Title }}`)),
+		},
+	}
+	for topics, want := range map[string]string{
+		`["mobile"]`:             "mobile: Add the widget",
+		`["ios", "mobile"]`:      "mobile: Add the widget",
+		`["frontend", "mobile"]`: "frontend: Add the widget",
+		`["backend"]`:            "default: Add the widget",
+		`[]`:                     "default: Add the widget",
+	} {
+		pr := testEvent(t, `{"repository": {"topics": `+topics+`}, "pull_request": {"title": "Add the widget"}}`)
+		got, err := (notifier{}).render(b, pr, prDetails{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("topics %s: body = %q, want %q", topics, got, want)
+		}
+	}
+	pr := testEvent(t, `{"repository": {"topics": ["backend"]}}`)
+	if tmpl := (Backend{Type: "slack"}).bodyTemplate(pr); tmpl != nil {
+		t.Errorf("bodyTemplate = %s, want the built-in format", tmpl.Name())
+	}
+}
+
+func TestTitlePattern(t *testing.T) {
+	s := notifier{TitlePattern: regexp.MustCompile(`^(feat|fix|chore)(\([a-z]+\))?: `)}
+	for title, flagged := range map[string]bool{
+		"feat: add the widget":      false,
+		"fix(api): handle timeouts": false,
+		"Add the widget":            true,
+		"feature: add the widget":   true,
+	} {
+		pr := testEvent(t, fmt.Sprintf(`{"pull_request": {"title": %q}}`, title))
+		lines := s.details(pr, prDetails{})
+		if got := len(lines) == 1 && lines[0] == "⚠️ non-conventional title"; got != flagged {
+			t.Errorf("%q: details = %q, want flagged %t", title, lines, flagged)
+		}
+		if !flagged && len(lines) != 0 {
+			t.Errorf("%q: details = %q, want none", title, lines)
+		}
+	}
+	pr := testEvent(t, `{"pull_request": {"title": "Add the widget"}}`)
+	if lines := (notifier{}).details(pr, prDetails{}); len(lines) != 0 {
+		t.Errorf("details = %q, want none without TitlePattern", lines)
+	}
+}
+
+func TestRawTemplate(t *testing.T) {
+	tmpl := texttemplate.Must(texttemplate.New("body").Parse(`{"team": "{{ .Raw.repository.custom_properties.team }}", "sender": "{{ .Raw.sender.login }}"}`))
+	pr := testEvent(t, `{"repository": {"full_name": "octo/repo", "custom_properties": {"team": "payments"}}, "sender": {"login": "alice"}}`)
+	got, err := (notifier{}).render(Backend{Type: "webhook", Template: tmpl}, pr, prDetails{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"team": "payments", "sender": "alice"}`; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,115 @@
+package pulltabs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"appengine"
+	"appengine/urlfetch"
+)
+
+// discordColors converts Slack's named attachment colors for Discord.
+var discordColors = map[string]int{
+	"good":    discordGood,
+	"warning": 0xdaa038,
+	"danger":  0xa30200,
+}
+
+// discordColor converts a Slack attachment color, named or "#rrggbb".
+func discordColor(color string) int {
+	if c, ok := discordColors[color]; ok {
+		return c
+	}
+	c, _ := strconv.ParseInt(strings.TrimPrefix(color, "#"), 16, 32)
+	return int(c)
+}
+
+// reviewColor is the attachment color for a follow-up on a review.
+func (s notifier) reviewColor(state string) string {
+	if state != "changes_requested" {
+		return "good"
+	}
+	if s.ChangesColor != "" {
+		return s.ChangesColor
+	}
+	return "danger"
+}
+
+func (s notifier) reviewText(rp reviewPost) string {
+	if rp.Review.State == "changes_requested" {
+		return s.prefixed(fmt.Sprintf(s.tr("reviewed changes"), rp.Review.User.Login))
+	}
+	return s.prefixed(fmt.Sprintf(s.tr("reviewed approved"), rp.Review.User.Login))
+}
+
+// followUpBody renders a review follow-up for a backend, replying in the
+// pull request's thread for "slackbot" backends when there is one.
+func (s notifier) followUpBody(b Backend, rp reviewPost, threadTS string) ([]byte, error) {
+	pr := rp.PullRequest
+	color := s.reviewColor(rp.Review.State)
+	switch b.Type {
+	case "discord":
+		return json.Marshal(&discordMessage{
+			Content: s.reviewText(rp),
+			Embeds:  []discordEmbed{discordEmbed{Title: pr.Title, URL: rp.Review.HTMLURL, Color: discordColor(color)}},
+		})
+	case "telegram":
+		text := fmt.Sprintf("<b>%s</b>\n%s", s.reviewText(rp), telegramHTML(fmt.Sprintf("<%s|%s>", rp.Review.HTMLURL, pr.Title)))
+		return json.Marshal(&telegramMessage{ChatID: b.Channel, Text: text, ParseMode: "HTML"})
+	}
+	m := slackMessage{
+		Text: s.reviewText(rp),
+		Attachments: []Attachment{
+			Attachment{
+				Color:     color,
+				Title:     pr.Title,
+				TitleLink: rp.Review.HTMLURL,
+			},
+		},
+	}
+	if b.Type == "slackbot" {
+		m.Channel = b.Channel
+		m.ThreadTS = threadTS
+	}
+	return json.Marshal(&m)
+}
+
+// followUp posts a submitted review to the pull request's backends.
+func (s notifier) followUp(c appengine.Context, rp reviewPost) {
+	reqID := appengine.RequestID(c)
+	client := urlfetch.Client(c)
+	threads, err := findThreads(c, rp.Repository.FullName, rp.PullRequest.Number)
+	if err != nil {
+		c.Infof("Failed to find threads for request %s. Error: %s", reqID, err)
+	}
+	var d prDetails
+	if len(s.FileRoutes) > 0 {
+		d.Files, err = s.changedFiles(client, rp.Repository.FullName, rp.PullRequest.Number)
+		if err != nil {
+			c.Infof("Failed to fetch changed files for request %s. Error: %s", reqID, err)
+		}
+	}
+	for _, b := range s.backendsFor(rp.pullRequestPost, d) {
+		if b.Type == "pubsub" {
+			continue
+		}
+		var threadTS string
+		for _, t := range threads {
+			if t.Backend == b.Channel {
+				threadTS = t.TS
+			}
+		}
+		body, err := s.followUpBody(b, rp, threadTS)
+		if err == nil {
+			if b.Type == "slackbot" {
+				s.throttle(b.Channel)
+			}
+			_, err = postBody(client, b, string(body))
+		}
+		if err != nil {
+			c.Infof("Failed to post %s review follow-up for request %s. Error: %s", b.Type, reqID, err)
+		}
+	}
+}
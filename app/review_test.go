@@ -0,0 +1,93 @@
+package pulltabs
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"appengine/aetest"
+)
+
+func reviewEvent(t *testing.T, state string) reviewPost {
+	var rp reviewPost
+	body := `{"action": "submitted", "pull_request": {"number": 3, "title": "Add the widget"}, "review": {"state": "` + state + `", "html_url": "https://github.com/octo/repo/pull/3#pullrequestreview-1", "user": {"login": "alice"}}}`
+	if err := json.Unmarshal([]byte(body), &rp); err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+func TestChangesRequestedColor(t *testing.T) {
+	tests := []struct {
+		s       notifier
+		state   string
+		color   string
+		discord int
+	}{
+		{notifier{}, "changes_requested", "danger", 0xa30200},
+		{notifier{ChangesColor: "#ff8800"}, "changes_requested", "#ff8800", 0xff8800},
+		{notifier{ChangesColor: "warning"}, "changes_requested", "warning", 0xdaa038},
+		{notifier{ChangesColor: "#ff8800"}, "approved", "good", discordGood},
+	}
+	for _, tt := range tests {
+		rp := reviewEvent(t, tt.state)
+		b, err := tt.s.followUpBody(Backend{Type: "slack"}, rp, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m slackMessage
+		if err := json.Unmarshal(b, &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Attachments[0].Color != tt.color {
+			t.Errorf("%s with %q: color = %q, want %q", tt.state, tt.s.ChangesColor, m.Attachments[0].Color, tt.color)
+		}
+		b, err = tt.s.followUpBody(Backend{Type: "discord"}, rp, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dm discordMessage
+		if err := json.Unmarshal(b, &dm); err != nil {
+			t.Fatal(err)
+		}
+		if dm.Embeds[0].Color != tt.discord {
+			t.Errorf("%s with %q: Discord color = %#x, want %#x", tt.state, tt.s.ChangesColor, dm.Embeds[0].Color, tt.discord)
+		}
+	}
+}
+
+func TestFollowUpThread(t *testing.T) {
+	b := Backend{Type: "slackbot", Channel: "#reviews"}
+	body, err := notifier{}.followUpBody(b, reviewEvent(t, "approved"), "1700000000.000100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m slackMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Text != "alice approved" || m.Channel != "#reviews" || m.ThreadTS != "1700000000.000100" {
+		t.Errorf("follow-up = %+v, want alice's approval in the thread", m)
+	}
+}
+
+func TestFollowUpBeforeResponse(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	slack := newHook(http.StatusOK)
+	defer slack.Close()
+
+	s := notifier{SlackURL: slack.URL, ReviewUpdates: true}
+	body := `{"action": "submitted", "pull_request": {"number": 3, "title": "Add the widget"}, "review": {"state": "approved", "user": {"login": "alice"}}}`
+	w, _ := servePayload(c, s, "pull_request_review", body)
+	if w.Code != http.StatusOK {
+		t.Errorf("payload = %d, want 200", w.Code)
+	}
+	if posts := slack.posts(); len(posts) != 1 || !strings.Contains(posts[0], "alice approved") {
+		t.Errorf("posted %q by the response, want the follow-up", posts)
+	}
+}
@@ -0,0 +1,92 @@
+package pulltabs
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"appengine"
+	"appengine/taskqueue"
+)
+
+func (s notifier) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func (s notifier) location() *time.Location {
+	if s.Location != nil {
+		return s.Location
+	}
+	return time.UTC
+}
+
+// defaultMorningHour is when deferred notifications are sent without a
+// MorningHour.
+const defaultMorningHour = 9
+
+func (s notifier) morningHour() int {
+	if s.MorningHour > 0 {
+		return s.MorningHour
+	}
+	return defaultMorningHour
+}
+
+func (s notifier) activeDay(d time.Weekday) bool {
+	if len(s.ActiveDays) == 0 {
+		return true
+	}
+	for _, a := range s.ActiveDays {
+		if a == d {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyAt returns when a notification for a pull request labeled at t should
+// be sent. Labels applied on an active day are sent right away, others wait
+// until MorningHour on the next active day.
+func (s notifier) notifyAt(t time.Time) time.Time {
+	local := t.In(s.location())
+	if s.activeDay(local.Weekday()) {
+		return t
+	}
+	for i := 1; i < 7; i++ {
+		d := local.AddDate(0, 0, i)
+		if s.activeDay(d.Weekday()) {
+			return time.Date(d.Year(), d.Month(), d.Day(), s.morningHour(), 0, 0, 0, d.Location())
+		}
+	}
+	return t
+}
+
+// schedule queues a notification for /deliver, which posts it within its own
+// request so that retries and Datastore writes keep a valid context. Outside
+// the active days it waits until the next one.
+func (s notifier) schedule(c appengine.Context, body []byte, deliveryID string) {
+	s.deferNotification(c, body, deliveryID, 0, s.notifyAt(s.now()))
+}
+
+// deferNotification queues the original webhook body to be posted to /deliver
+// at the given time. checks counts the CI checks already made for it.
+func (s notifier) deferNotification(c appengine.Context, body []byte, deliveryID string, checks int, at time.Time) {
+	reqID := appengine.RequestID(c)
+	t := &taskqueue.Task{
+		Path:    "/deliver",
+		Payload: body,
+		Header: http.Header{
+			"X-GitHub-Delivery":    []string{deliveryID},
+			"X-PullTabs-CI-Checks": []string{strconv.Itoa(checks)},
+		},
+		Method: "POST",
+		ETA:    at,
+	}
+	if _, err := taskqueue.Add(c, t, ""); err != nil {
+		c.Infof("Failed to defer notification for request %s. Error: %s", reqID, err)
+		return
+	}
+	c.Infof("Deferred notification for request %s until %s", reqID, at.Format(time.RFC1123))
+}
@@ -0,0 +1,47 @@
+package pulltabs
+
+import (
+	"testing"
+	"time"
+)
+
+func weekdays() []time.Weekday {
+	return []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+}
+
+func TestNotifyAtActiveDay(t *testing.T) {
+	wednesday := time.Date(2026, time.October, 14, 15, 30, 0, 0, time.UTC)
+	s := notifier{ActiveDays: weekdays(), Now: func() time.Time { return wednesday }}
+	if got := s.notifyAt(s.now()); !got.Equal(wednesday) {
+		t.Errorf("notifyAt(Wednesday) = %s, want right away", got)
+	}
+}
+
+func TestNotifyAtInactiveDay(t *testing.T) {
+	saturday := time.Date(2026, time.October, 17, 15, 30, 0, 0, time.UTC)
+	s := notifier{ActiveDays: weekdays(), MorningHour: 8, Now: func() time.Time { return saturday }}
+	want := time.Date(2026, time.October, 19, 8, 0, 0, 0, time.UTC)
+	if got := s.notifyAt(s.now()); !got.Equal(want) {
+		t.Errorf("notifyAt(Saturday) = %s, want %s", got, want)
+	}
+}
+
+func TestNotifyAtDefaultMorningHour(t *testing.T) {
+	saturday := time.Date(2026, time.October, 17, 15, 30, 0, 0, time.UTC)
+	s := notifier{ActiveDays: weekdays(), Now: func() time.Time { return saturday }}
+	want := time.Date(2026, time.October, 19, 9, 0, 0, 0, time.UTC)
+	if got := s.notifyAt(s.now()); !got.Equal(want) {
+		t.Errorf("notifyAt(Saturday) = %s, want %s", got, want)
+	}
+}
+
+func TestNotifyAtLocation(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	// Friday evening in UTC is already Saturday in Tokyo.
+	friday := time.Date(2026, time.October, 16, 20, 0, 0, 0, time.UTC)
+	s := notifier{ActiveDays: weekdays(), Location: tokyo, Now: func() time.Time { return friday }}
+	want := time.Date(2026, time.October, 19, 9, 0, 0, 0, tokyo)
+	if got := s.notifyAt(s.now()); !got.Equal(want) {
+		t.Errorf("notifyAt(Saturday in Tokyo) = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,398 @@
+package pulltabs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+const slackAPIURL = "https://slack.com/api/"
+
+// commentLength is the most characters of a review comment posted to a thread.
+const commentLength = 500
+
+// slackEscaper escapes the characters Slack reads as markup, so that user text
+// cannot mention anyone or add links.
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func slackEscape(text string) string {
+	return slackEscaper.Replace(text)
+}
+
+// slackUnescaper reverses slackEscaper for backends without Slack markup.
+var slackUnescaper = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+
+// slackAPIResponse is the reply to a Slack Web API call.
+type slackAPIResponse struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+	User    struct {
+		ID string `json:"id"`
+	} `json:"user"`
+}
+
+// slackAPI calls a Slack Web API method with a JSON body. Slack reports most
+// failures with a 200 response, so ok is checked as well as the status.
+func slackAPI(client *http.Client, token, method string, body []byte) (slackAPIResponse, error) {
+	var res slackAPIResponse
+	req, err := http.NewRequest("POST", slackAPIURL+method, bytes.NewReader(body))
+	if err != nil {
+		return res, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	return slackDo(client, token, method, req)
+}
+
+// slackDo sends a Slack Web API request and checks the response.
+func slackDo(client *http.Client, token, method string, req *http.Request) (slackAPIResponse, error) {
+	var res slackAPIResponse
+	req.Header.Set("Authorization", "Bearer "+token)
+	r, err := client.Do(req)
+	if err != nil {
+		return res, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return res, fmt.Errorf("%s: %s", method, r.Status)
+	}
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		return res, err
+	}
+	if !res.OK {
+		return res, errors.New(method + ": " + res.Error)
+	}
+	return res, nil
+}
+
+// lookupSlackUser finds the Slack user ID for a GitHub login from the public
+// email on their GitHub profile.
+func (s notifier) lookupSlackUser(client *http.Client, token, login string) (string, error) {
+	var user struct {
+		Email string `json:"email"`
+	}
+	if err := s.githubGet(client, "/users/"+login, &user); err != nil {
+		return "", err
+	}
+	if user.Email == "" {
+		return "", fmt.Errorf("no public email for %s", login)
+	}
+	req, err := http.NewRequest("GET", slackAPIURL+"users.lookupByEmail?email="+url.QueryEscape(user.Email), nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := slackDo(client, token, "users.lookupByEmail", req)
+	return res.User.ID, err
+}
+
+// slackUsers maps the logins that SlackUsers is missing, when UserFallback
+// is "lookup". Logins that cannot be found are left out.
+func (s notifier) slackUsers(c appengine.Context, client *http.Client, logins []string) map[string]string {
+	users := map[string]string{}
+	if s.UserFallback != "lookup" {
+		return users
+	}
+	var token string
+	for _, b := range s.allBackends() {
+		if b.Type == "slackbot" {
+			token = b.Token
+			break
+		}
+	}
+	if token == "" {
+		return users
+	}
+	for _, login := range logins {
+		if _, ok := s.SlackUsers[login]; ok {
+			continue
+		}
+		id, err := s.lookupSlackUser(client, token, login)
+		if err != nil {
+			c.Infof("Failed to look up Slack user %s for request %s. Error: %s", login, appengine.RequestID(c), err)
+			continue
+		}
+		users[login] = id
+	}
+	return users
+}
+
+// slackEventAge is the oldest Slack event request accepted, to stop replays.
+const slackEventAge = 5 * time.Minute
+
+// validSlackSignature checks the X-Slack-Signature of an Events API request.
+func (s notifier) validSlackSignature(req *http.Request, body []byte) bool {
+	ts := req.Header.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if s.SlackSecret == "" || err != nil {
+		return false
+	}
+	age := s.now().Sub(time.Unix(sec, 0))
+	if age > slackEventAge || age < -slackEventAge {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(s.SlackSecret))
+	fmt.Fprintf(mac, "v0:%s:%s", ts, body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(req.Header.Get("X-Slack-Signature")))
+}
+
+// slackEvent is an Events API request. Only reaction_added events are
+// subscribed to.
+type slackEvent struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Event     struct {
+		Type     string `json:"type"`
+		Reaction string `json:"reaction"`
+		Item     struct {
+			Channel string `json:"channel"`
+			TS      string `json:"ts"`
+		} `json:"item"`
+	} `json:"event"`
+}
+
+// slackEvents handles reactions on messages posted by "slackbot" backends. The
+// DoneReaction on a pull request message removes the label from it.
+func (s notifier) slackEvents(c appengine.Context, client *http.Client, w http.ResponseWriter, req *http.Request) {
+	reqID := appengine.RequestID(c)
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, "Could not read request", http.StatusInternalServerError)
+		return
+	}
+	if !s.validSlackSignature(req, body) {
+		c.Infof("Slack signature invalid for request %s", reqID)
+		http.Error(w, "Signature invalid", http.StatusUnauthorized)
+		return
+	}
+	var e slackEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		c.Infof("Failed to parse JSON for request %s: %s", reqID, err)
+		http.Error(w, "Failed to parse JSON", http.StatusBadRequest)
+		return
+	}
+	if e.Type == "url_verification" {
+		w.Header().Set("CONTENT-TYPE", "text/plain; charset=UTF-8")
+		io.WriteString(w, e.Challenge)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if e.Event.Type != "reaction_added" || e.Event.Reaction != strings.Trim(s.DoneReaction, ":") {
+		return
+	}
+	t, ok, err := findThread(c, e.Event.Item.Channel, e.Event.Item.TS)
+	if err != nil {
+		c.Infof("Failed to find thread for request %s. Error: %s", reqID, err)
+		return
+	}
+	if !ok {
+		c.Infof("Ignoring reaction to an unknown message for request %s", reqID)
+		return
+	}
+	var pr pullRequestPost
+	path := fmt.Sprintf("/repos/%s/pulls/%d", t.Repo, t.Number)
+	if err := s.githubGet(client, path, &pr.PullRequest); err != nil {
+		c.Infof("Failed to fetch pull request for request %s. Error: %s", reqID, err)
+		return
+	}
+	pr.Number = t.Number
+	pr.Repository.FullName = t.Repo
+	s.removeLabels(c, client, pr)
+}
+
+// labelAnchor finds the thread for a ThreadLabels label in a "slackbot"
+// backend's channel today, posting its first message when there is none yet.
+// Only the delivery that claims the anchor posts it; others wait for it.
+func (s notifier) labelAnchor(c appengine.Context, client *http.Client, b Backend, label string) (string, error) {
+	day := s.now().In(s.location()).Format("2006-01-02")
+	key := anchorKey(c, label, b.Channel, day)
+	for polls := 0; ; polls++ {
+		a, claimed, err := claimAnchor(c, key)
+		if err != nil || a.TS != "" {
+			return a.TS, err
+		}
+		if claimed {
+			break
+		}
+		if polls == anchorPolls {
+			return "", fmt.Errorf("the %s thread for %s is still being posted", label, day)
+		}
+		time.Sleep(anchorPollInterval)
+	}
+	body, err := json.Marshal(&slackMessage{Channel: b.Channel, Text: s.prefixed(fmt.Sprintf(s.tr("anchor"), label, day))})
+	if err == nil {
+		s.throttle(b.Channel)
+		var res slackAPIResponse
+		if res, err = slackAPI(client, b.Token, "chat.postMessage", body); err == nil {
+			a := anchor{Channel: res.Channel, TS: res.TS}
+			_, err = datastore.Put(c, key, &a)
+			return a.TS, err
+		}
+	}
+	// Let a later delivery post the anchor instead.
+	datastore.Delete(c, key)
+	return "", err
+}
+
+// addReaction reacts to a message posted by a "slackbot" backend.
+func addReaction(client *http.Client, b Backend, msg slackAPIResponse, emoji string) error {
+	body, err := json.Marshal(map[string]string{
+		"channel":   msg.Channel,
+		"timestamp": msg.TS,
+		"name":      strings.Trim(emoji, ":"),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = slackAPI(client, b.Token, "reactions.add", body)
+	return err
+}
+
+// formatDuration renders a duration to the minute, using at most two units,
+// such as "3d 4h" or "25m".
+func formatDuration(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	days := int(d / (24 * time.Hour))
+	hours := int(d/time.Hour) % 24
+	minutes := int(d/time.Minute) % 60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+// closeSummary describes how a pull request was closed, with the time it sat in
+// the review queue when that is known.
+func (s notifier) closeSummary(pr pullRequestPost, approvals int, queued time.Duration) string {
+	verb := s.tr("closed")
+	if pr.PullRequest.Merged {
+		verb = s.tr("merged")
+	}
+	text := fmt.Sprintf(verb, formatDuration(pr.PullRequest.ClosedAt.Sub(pr.PullRequest.CreatedAt)))
+	if approvals == 1 {
+		text += " " + s.tr("approval")
+	} else {
+		text += " " + fmt.Sprintf(s.tr("approvals"), approvals)
+	}
+	if queued > 0 {
+		text += ", " + fmt.Sprintf(s.tr("queued"), formatDuration(queued))
+	}
+	return text
+}
+
+// queueTime is how long a pull request has been in the review queue, since
+// the first of its threads was posted when it was labeled.
+func (s notifier) queueTime(threads []thread) time.Duration {
+	var labeled time.Time
+	for _, t := range threads {
+		if labeled.IsZero() || t.Posted.Before(labeled) {
+			labeled = t.Posted
+		}
+	}
+	if labeled.IsZero() {
+		return 0
+	}
+	return s.now().Sub(labeled)
+}
+
+func approvals(reviews []review) int {
+	approved := map[string]bool{}
+	for _, r := range reviews {
+		if r.State == "APPROVED" {
+			approved[r.User.Login] = true
+		}
+	}
+	return len(approved)
+}
+
+// summarizeThreads replies to every thread started for a pull request with a
+// summary of how it was closed.
+func (s notifier) summarizeThreads(c appengine.Context, client *http.Client, pr pullRequestPost) {
+	reqID := appengine.RequestID(c)
+	threads, err := findThreads(c, pr.Repository.FullName, pr.Number)
+	if err != nil {
+		c.Infof("Failed to find threads for request %s. Error: %s", reqID, err)
+		return
+	}
+	if len(threads) == 0 {
+		c.Infof("No threads to summarize for request %s", reqID)
+		return
+	}
+	var reviews []review
+	path := fmt.Sprintf("/repos/%s/pulls/%d/reviews?per_page=100", pr.Repository.FullName, pr.Number)
+	if err := s.githubGet(client, path, &reviews); err != nil {
+		c.Infof("Failed to fetch reviews for request %s. Error: %s", reqID, err)
+	}
+	s.reply(c, client, threads, s.closeSummary(pr, approvals(reviews), s.queueTime(threads)))
+}
+
+// replyToComment posts a new review comment to the threads started for its
+// pull request.
+func (s notifier) replyToComment(c appengine.Context, client *http.Client, rc reviewCommentPost) {
+	reqID := appengine.RequestID(c)
+	threads, err := findThreads(c, rc.Repository.FullName, rc.PullRequest.Number)
+	if err != nil {
+		c.Infof("Failed to find threads for request %s. Error: %s", reqID, err)
+		return
+	}
+	if len(threads) == 0 {
+		c.Infof("No threads to reply to for request %s", reqID)
+		return
+	}
+	text, cut := excerpt(rc.Comment.Body, commentLength)
+	text = slackEscape(text)
+	if cut {
+		text += "…"
+	}
+	text = fmt.Sprintf(s.tr("commented"), rc.Comment.User.Login, text, rc.Comment.HTMLURL)
+	s.reply(c, client, threads, text)
+}
+
+// reply posts text into each thread, within the channel rate limits.
+func (s notifier) reply(c appengine.Context, client *http.Client, threads []thread, text string) {
+	reqID := appengine.RequestID(c)
+	for _, t := range threads {
+		b, ok := s.botBackend(t.Backend)
+		if !ok {
+			continue
+		}
+		s.throttle(b.Channel)
+		body, err := json.Marshal(&slackMessage{Channel: t.Channel, ThreadTS: t.TS, Text: s.prefixed(text)})
+		if err == nil {
+			_, err = slackAPI(client, b.Token, "chat.postMessage", body)
+		}
+		if err != nil {
+			c.Infof("Failed to reply in thread for request %s. Error: %s", reqID, err)
+		}
+	}
+}
+
+// botBackend finds the "slackbot" backend for a configured channel.
+func (s notifier) botBackend(channel string) (Backend, bool) {
+	for _, b := range s.allBackends() {
+		if b.Type == "slackbot" && b.Channel == channel {
+			return b, true
+		}
+	}
+	return Backend{}, false
+}
@@ -0,0 +1,397 @@
+package pulltabs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"appengine/aetest"
+)
+
+// slackOK answers Slack Web API calls as a posted message.
+func slackOK(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, `{"ok": true, "channel": "C024BE91L", "ts": "1700000000.000200"}`)
+}
+
+func TestCloseSummaryThread(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	closed := time.Date(2026, 10, 13, 12, 30, 0, 0, time.UTC)
+	s := notifier{
+		Backends: []Backend{Backend{Type: "slackbot", Token: "xoxb-token", Channel: "#reviews"}},
+		Now:      func() time.Time { return closed },
+	}
+	pr := testEvent(t, `{"action": "closed", "number": 3, "repository": {"full_name": "octo/repo"}, "pull_request": {"merged": true, "created_at": "2026-10-12T09:00:00Z", "closed_at": "2026-10-13T12:30:00Z"}}`)
+	posted := slackAPIResponse{Channel: "C024BE91L", TS: "1700000000.000100"}
+	if err := saveThread(c, pr, "#reviews", posted, time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	client, st := stubClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repos/octo/repo/pulls/3/reviews" {
+			io.WriteString(w, `[{"state": "APPROVED", "user": {"login": "alice"}}, {"state": "COMMENTED", "user": {"login": "bob"}}]`)
+			return
+		}
+		slackOK(w, r)
+	})
+	s.summarizeThreads(c, client, pr)
+
+	if len(st.requests) != 2 || st.requests[1].URL.Path != "/api/chat.postMessage" {
+		t.Fatalf("requests = %d, want the reviews and one chat.postMessage", len(st.requests))
+	}
+	if auth := st.requests[1].Header.Get("Authorization"); auth != "Bearer xoxb-token" {
+		t.Errorf("Authorization = %q, want the bot token", auth)
+	}
+	var m slackMessage
+	if err := json.Unmarshal([]byte(st.bodies[1]), &m); err != nil {
+		t.Fatal(err)
+	}
+	want := slackMessage{Channel: "C024BE91L", ThreadTS: "1700000000.000100", Text: "Merged after 1d 3h with 1 approval, 1d 2h in review queue"}
+	if m.Channel != want.Channel || m.ThreadTS != want.ThreadTS || m.Text != want.Text {
+		t.Errorf("reply = %+v, want %+v", m, want)
+	}
+}
+
+func TestQueueTime(t *testing.T) {
+	labeled := time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)
+	now := time.Date(2026, 10, 13, 12, 30, 0, 0, time.UTC)
+	s := notifier{Now: func() time.Time { return now }}
+	threads := []thread{
+		thread{Backend: "#frontend", Posted: labeled.Add(5 * time.Minute)},
+		thread{Backend: "#reviews", Posted: labeled},
+	}
+	if got := s.queueTime(threads); got != 26*time.Hour+30*time.Minute {
+		t.Errorf("queueTime = %s, want 26h30m since the first thread", got)
+	}
+	if got := s.queueTime(nil); got != 0 {
+		t.Errorf("queueTime without threads = %s, want 0", got)
+	}
+
+	pr := testEvent(t, `{"action": "closed", "pull_request": {"merged": true, "created_at": "2026-10-12T09:00:00Z", "closed_at": "2026-10-13T12:30:00Z"}}`)
+	for queued, want := range map[time.Duration]string{
+		s.queueTime(threads): "Merged after 1d 3h with 2 approvals, 1d 2h in review queue",
+		0:                    "Merged after 1d 3h with 2 approvals",
+	} {
+		if got := (notifier{}).closeSummary(pr, 2, queued); got != want {
+			t.Errorf("closeSummary = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestAddReaction(t *testing.T) {
+	client, st := stubClient(slackOK)
+	b := Backend{Type: "slackbot", Token: "xoxb-token", Channel: "#reviews"}
+	msg := slackAPIResponse{Channel: "C024BE91L", TS: "1700000000.000100"}
+	if err := addReaction(client, b, msg, ":eyes:"); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.requests) != 1 || st.requests[0].URL.Path != "/api/reactions.add" {
+		t.Fatalf("requests = %d, want one reactions.add", len(st.requests))
+	}
+	var got map[string]string
+	if err := json.Unmarshal([]byte(st.bodies[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"channel": "C024BE91L", "timestamp": "1700000000.000100", "name": "eyes"}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestReplyToComment(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := notifier{ThreadReplies: true, Backends: []Backend{Backend{Type: "slackbot", Token: "xoxb-token", Channel: "#reviews"}}}
+	pr := testEvent(t, `{"number": 3, "repository": {"full_name": "octo/repo"}}`)
+	if err := saveThread(c, pr, "#reviews", slackAPIResponse{Channel: "C024BE91L", TS: "1700000000.000100"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	var rc reviewCommentPost
+	body := `{"action": "created", "comment": {"body": "Use <!channel> & a constant", "html_url": "https://github.com/octo/repo/pull/3#discussion_r1", "user": {"login": "alice"}}, "pull_request": {"number": 3}, "repository": {"full_name": "octo/repo"}}`
+	if err := json.Unmarshal([]byte(body), &rc); err != nil {
+		t.Fatal(err)
+	}
+	client, st := stubClient(slackOK)
+	s.replyToComment(c, client, rc)
+
+	if len(st.requests) != 1 {
+		t.Fatalf("made %d requests, want 1", len(st.requests))
+	}
+	var m slackMessage
+	if err := json.Unmarshal([]byte(st.bodies[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+	want := "*alice* commented: Use &lt;!channel&gt; &amp; a constant\n<https://github.com/octo/repo/pull/3#discussion_r1|View comment>"
+	if m.Channel != "C024BE91L" || m.ThreadTS != "1700000000.000100" || m.Text != want {
+		t.Errorf("reply = %+v, want %q in the thread", m, want)
+	}
+}
+
+func TestUserFallback(t *testing.T) {
+	for fallback, want := range map[string]string{
+		"":       "cc <@U024BE7LH> @bob",
+		"plain":  "cc <@U024BE7LH> bob",
+		"drop":   "cc <@U024BE7LH>",
+		"lookup": "cc <@U024BE7LH> bob",
+	} {
+		s := notifier{Mentions: true, UserFallback: fallback, SlackUsers: map[string]string{"alice": "U024BE7LH"}}
+		pr := testEvent(t, `{"pull_request": {"requested_reviewers": [{"login": "alice"}, {"login": "bob"}]}}`)
+		if lines := s.details(pr, prDetails{}); len(lines) != 1 || lines[0] != want {
+			t.Errorf("%q: details = %q, want %q", fallback, lines, want)
+		}
+	}
+}
+
+func TestLookupSlackUsers(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	client, st := stubClient(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/bob":
+			io.WriteString(w, `{"login": "bob", "email": "bob@example.com"}`)
+		case "/users/carol":
+			io.WriteString(w, `{"login": "carol", "email": null}`)
+		case "/api/users.lookupByEmail":
+			if r.URL.Query().Get("email") != "bob@example.com" {
+				io.WriteString(w, `{"ok": false, "error": "users_not_found"}`)
+				return
+			}
+			io.WriteString(w, `{"ok": true, "user": {"id": "W012A3CDE"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	s := notifier{
+		Mentions:     true,
+		UserFallback: "lookup",
+		SlackUsers:   map[string]string{"alice": "U024BE7LH"},
+		Backends:     []Backend{Backend{Type: "slackbot", Token: "xoxb-token", Channel: "#reviews"}},
+	}
+	users := s.slackUsers(c, client, []string{"alice", "bob", "carol"})
+	if len(users) != 1 || users["bob"] != "W012A3CDE" {
+		t.Errorf("looked up %v, want only bob", users)
+	}
+	for _, req := range st.requests {
+		if req.URL.Path == "/users/alice" {
+			t.Error("looked up alice, who is in SlackUsers")
+		}
+	}
+	pr := testEvent(t, `{"pull_request": {"requested_reviewers": [{"login": "alice"}, {"login": "bob"}, {"login": "carol"}]}}`)
+	if lines := s.details(pr, prDetails{SlackUsers: users}); len(lines) != 1 || lines[0] != "cc <@U024BE7LH> <@W012A3CDE> carol" {
+		t.Errorf("details = %q, want bob mentioned by his looked up ID", lines)
+	}
+}
+
+func TestLabelAnchor(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	client, st := stubClient(slackOK)
+	day := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	s := notifier{Now: func() time.Time { return day }}
+	b := Backend{Type: "slackbot", Token: "xoxb-token", Channel: "#reviews"}
+	first, err := s.labelAnchor(c, client, b, "backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.labelAnchor(c, client, b, "backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != "1700000000.000200" || second != first {
+		t.Errorf("thread_ts = %q and %q, want the same anchor", first, second)
+	}
+	if len(st.bodies) != 1 || !strings.Contains(st.bodies[0], "*backend* pull requests for 2026-10-15") {
+		t.Errorf("posted %q, want one anchor message", st.bodies)
+	}
+
+	s.Now = func() time.Time { return day.Add(24 * time.Hour) }
+	if next, err := s.labelAnchor(c, client, b, "backend"); err != nil || len(st.bodies) != 2 {
+		t.Errorf("next day anchor = %q, %v after %d posts, want a new anchor", next, err, len(st.bodies))
+	}
+	if _, err := s.labelAnchor(c, client, b, "frontend"); err != nil || len(st.bodies) != 3 {
+		t.Errorf("other label anchor posted %d messages, want a new anchor", len(st.bodies))
+	}
+}
+
+func TestLabelAnchorConcurrent(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	client, st := stubClient(slackOK)
+	day := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	s := notifier{Now: func() time.Time { return day }}
+	b := Backend{Type: "slackbot", Token: "xoxb-token", Channel: "#reviews"}
+
+	const deliveries = 2
+	var wg sync.WaitGroup
+	anchors := make(chan string, deliveries)
+	for i := 0; i < deliveries; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ts, err := s.labelAnchor(c, client, b, "backend")
+			if err != nil {
+				t.Error(err)
+			}
+			anchors <- ts
+		}()
+	}
+	wg.Wait()
+	close(anchors)
+	for ts := range anchors {
+		if ts != "1700000000.000200" {
+			t.Errorf("thread_ts = %q, want the one anchor", ts)
+		}
+	}
+	if len(st.bodies) != 1 {
+		t.Errorf("posted %d anchors, want exactly 1", len(st.bodies))
+	}
+}
+
+func TestLabelAnchorFailedPost(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	failing, _ := stubClient(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"ok": false, "error": "channel_not_found"}`)
+	})
+	s := notifier{}
+	b := Backend{Type: "slackbot", Token: "xoxb-token", Channel: "#reviews"}
+	if _, err := s.labelAnchor(c, failing, b, "backend"); err == nil {
+		t.Fatal("labelAnchor succeeded, want the Slack error")
+	}
+	client, st := stubClient(slackOK)
+	if ts, err := s.labelAnchor(c, client, b, "backend"); err != nil || ts != "1700000000.000200" {
+		t.Errorf("anchor after a failed post = %q, %v, want a new anchor", ts, err)
+	}
+	if len(st.bodies) != 1 {
+		t.Errorf("posted %d anchors after the failure, want 1", len(st.bodies))
+	}
+}
+
+// slackRequest builds an Events API request signed with secret at ts.
+func slackRequest(secret string, ts time.Time, body string) *http.Request {
+	req := httptest.NewRequest("POST", "/slack/events", strings.NewReader(body))
+	stamp := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", stamp, body)
+	req.Header.Set("X-Slack-Request-Timestamp", stamp)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestSlackEvents(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	s := notifier{SlackSecret: "secret", DoneReaction: ":white_check_mark:", Now: func() time.Time { return now }}
+	reaction := func(name string) string {
+		return `{"type": "event_callback", "event": {"type": "reaction_added", "reaction": "` + name + `", "item": {"channel": "C024BE91L", "ts": "1700000000.000200"}}}`
+	}
+	for _, tc := range []struct {
+		name   string
+		req    *http.Request
+		status int
+		body   string
+		log    string
+	}{
+		{"challenge", slackRequest("secret", now, `{"type": "url_verification", "challenge": "3eZbrw1aB"}`), http.StatusOK, "3eZbrw1aB", ""},
+		{"wrong secret", slackRequest("other", now, reaction("white_check_mark")), http.StatusUnauthorized, "Signature invalid\n", "Slack signature invalid"},
+		{"stale", slackRequest("secret", now.Add(-10*time.Minute), reaction("white_check_mark")), http.StatusUnauthorized, "Signature invalid\n", "Slack signature invalid"},
+		{"other reaction", slackRequest("secret", now, reaction("eyes")), http.StatusOK, "", ""},
+		{"unknown message", slackRequest("secret", now, reaction("white_check_mark")), http.StatusOK, "", "Ignoring reaction to an unknown message"},
+	} {
+		lc := &logContext{Context: c}
+		w := httptest.NewRecorder()
+		client, st := stubClient(http.NotFound)
+		s.slackEvents(lc, client, w, tc.req)
+		if w.Code != tc.status || w.Body.String() != tc.body {
+			t.Errorf("%s: response = %d %q, want %d %q", tc.name, w.Code, w.Body.String(), tc.status, tc.body)
+		}
+		if tc.log != "" && !lc.logged(tc.log) {
+			t.Errorf("%s: logged %q, want %q", tc.name, lc.info, tc.log)
+		}
+		if tc.log == "" && len(lc.info) > 0 {
+			t.Errorf("%s: logged %q, want nothing", tc.name, lc.info)
+		}
+		if len(st.requests) > 0 {
+			t.Errorf("%s: made %d requests, want none", tc.name, len(st.requests))
+		}
+	}
+}
+
+func TestSlackEventRemovesLabel(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	pr := testEvent(t, `{"number": 12, "repository": {"full_name": "octo/repo"}}`)
+	if err := saveThread(c, pr, "#reviews", slackAPIResponse{Channel: "C024BE91L", TS: "1700000000.000200"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	client, st := stubClient(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/repos/octo/repo/pulls/12":
+			io.WriteString(w, `{"labels": [{"name": "backend"}, {"name": "awaiting review"}]}`)
+		case r.Method == "DELETE":
+			io.WriteString(w, `[]`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	s := notifier{Label: "awaiting review", GitHubToken: "ghp_token", SlackSecret: "secret", DoneReaction: ":white_check_mark:", Now: func() time.Time { return now }}
+	body := `{"type": "event_callback", "event": {"type": "reaction_added", "reaction": "white_check_mark", "item": {"channel": "C024BE91L", "ts": "1700000000.000200"}}}`
+	w := httptest.NewRecorder()
+	s.slackEvents(c, client, w, slackRequest("secret", now, body))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("response = %d, want 200", w.Code)
+	}
+	if len(st.requests) != 2 {
+		t.Fatalf("made %d requests, want the pull request and one label removal", len(st.requests))
+	}
+	req := st.requests[1]
+	if req.Method != "DELETE" || req.URL.EscapedPath() != "/repos/octo/repo/issues/12/labels/awaiting%20review" {
+		t.Errorf("request = %s %s, want the label removed", req.Method, req.URL.EscapedPath())
+	}
+}
@@ -0,0 +1,170 @@
+package pulltabs
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/urlfetch"
+)
+
+const (
+	// statsPeriod is how far back the weekly stats look.
+	statsPeriod = 7 * 24 * time.Hour
+	// topReviewers is how many reviewers the weekly stats name.
+	topReviewers = 3
+)
+
+// reviewStats summarizes a week of labeled pull requests.
+type reviewStats struct {
+	Labeled   int
+	Reviewed  int
+	Average   time.Duration
+	Reviewers []reviewerCount
+}
+
+type reviewerCount struct {
+	Login string
+	Count int
+}
+
+// byCount sorts reviewers with the most reviews first.
+type byCount []reviewerCount
+
+func (a byCount) Len() int      { return len(a) }
+func (a byCount) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byCount) Less(i, j int) bool {
+	if a[i].Count != a[j].Count {
+		return a[i].Count > a[j].Count
+	}
+	return a[i].Login < a[j].Login
+}
+
+func pullKey(repo string, number int) string {
+	return fmt.Sprintf("%s#%d", repo, number)
+}
+
+// weeklyStats computes the stats from audited deliveries and the reviews of
+// each pull request, keyed by pullKey. The time to review runs from the first
+// notification to the first review after it.
+func weeklyStats(deliveries []delivery, reviews map[string][]review, since time.Time) reviewStats {
+	labeled := map[string]time.Time{}
+	for _, d := range deliveries {
+		if d.Action != "labeled" || d.Received.Before(since) {
+			continue
+		}
+		if d.DeliveryStatus != "delivered" && d.DeliveryStatus != "partial" {
+			continue
+		}
+		key := pullKey(d.Repo, d.Number)
+		if first, ok := labeled[key]; !ok || d.Received.Before(first) {
+			labeled[key] = d.Received
+		}
+	}
+	var st reviewStats
+	var total time.Duration
+	counts := map[string]int{}
+	for key, notified := range labeled {
+		st.Labeled++
+		var first time.Time
+		for _, r := range reviews[key] {
+			if r.SubmittedAt.Before(notified) {
+				continue
+			}
+			counts[r.User.Login]++
+			if first.IsZero() || r.SubmittedAt.Before(first) {
+				first = r.SubmittedAt
+			}
+		}
+		if !first.IsZero() {
+			st.Reviewed++
+			total += first.Sub(notified)
+		}
+	}
+	if st.Reviewed > 0 {
+		st.Average = total / time.Duration(st.Reviewed)
+	}
+	for login, n := range counts {
+		st.Reviewers = append(st.Reviewers, reviewerCount{Login: login, Count: n})
+	}
+	sort.Sort(byCount(st.Reviewers))
+	if len(st.Reviewers) > topReviewers {
+		st.Reviewers = st.Reviewers[:topReviewers]
+	}
+	return st
+}
+
+func (s notifier) statsText(st reviewStats) string {
+	lines := []string{
+		s.tr("stats"),
+		fmt.Sprintf(s.tr("stats labeled"), st.Labeled),
+	}
+	if st.Reviewed > 0 {
+		lines = append(lines, fmt.Sprintf(s.tr("stats average"), formatDuration(st.Average)))
+	}
+	if len(st.Reviewers) > 0 {
+		var names []string
+		for _, r := range st.Reviewers {
+			names = append(names, fmt.Sprintf("%s (%d)", r.Login, r.Count))
+		}
+		lines = append(lines, fmt.Sprintf(s.tr("stats top"), strings.Join(names, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// stats posts the weekly review stats, computed from the Delivery entities
+// saved when Audit is enabled.
+func (s notifier) stats(c appengine.Context, w http.ResponseWriter, req *http.Request) {
+	reqID := appengine.RequestID(c)
+	if req.Header.Get("X-AppEngine-Cron") == "" {
+		c.Infof("Rejecting stats request %s not sent by cron", reqID)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !s.WeeklyStats || s.inMaintenance(c) {
+		c.Infof("Skipping stats request %s", reqID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	since := s.now().Add(-statsPeriod)
+	var deliveries []delivery
+	q := datastore.NewQuery("Delivery").Filter("Received >=", since)
+	if _, err := q.GetAll(c, &deliveries); err != nil {
+		c.Infof("Failed to query deliveries for request %s. Error: %s", reqID, err)
+		http.Error(w, "Failed to query deliveries", http.StatusInternalServerError)
+		return
+	}
+	client := urlfetch.Client(c)
+	reviews := map[string][]review{}
+	for _, d := range deliveries {
+		key := pullKey(d.Repo, d.Number)
+		if _, ok := reviews[key]; ok || d.Action != "labeled" {
+			continue
+		}
+		var rs []review
+		path := fmt.Sprintf("/repos/%s/pulls/%d/reviews?per_page=100", d.Repo, d.Number)
+		if err := s.githubGet(client, path, &rs); err != nil {
+			c.Infof("Failed to fetch reviews of %s for request %s. Error: %s", key, reqID, err)
+		}
+		reviews[key] = rs
+	}
+	text := s.statsText(weeklyStats(deliveries, reviews, since))
+	for _, b := range s.backends() {
+		if b.Type == "pubsub" {
+			continue
+		}
+		body, err := s.textBody(b, text)
+		if err == nil {
+			_, err = postBody(client, b, body)
+		}
+		if err != nil {
+			c.Infof("Failed to post %s stats for request %s. Error: %s", b.Type, reqID, err)
+		}
+	}
+	c.Infof("Successful handling of stats for request %s", reqID)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,54 @@
+package pulltabs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWeeklyStats(t *testing.T) {
+	now := time.Date(2026, time.October, 19, 9, 0, 0, 0, time.UTC)
+	since := now.Add(-statsPeriod)
+	at := func(days, hours int) time.Time {
+		return since.Add(time.Duration(days*24+hours) * time.Hour)
+	}
+	reviewAt := func(login string, t time.Time) review {
+		r := reviewBy(login, "APPROVED")
+		r.SubmittedAt = t
+		return r
+	}
+	deliveries := []delivery{
+		delivery{Repo: "octo/repo", Number: 1, Action: "labeled", DeliveryStatus: "delivered", Received: at(1, 0)},
+		// Labeled again later: the first notification counts.
+		delivery{Repo: "octo/repo", Number: 1, Action: "labeled", DeliveryStatus: "delivered", Received: at(2, 0)},
+		delivery{Repo: "octo/repo", Number: 2, Action: "labeled", DeliveryStatus: "partial", Received: at(3, 0)},
+		delivery{Repo: "octo/repo", Number: 3, Action: "labeled", DeliveryStatus: "delivered", Received: at(4, 0)},
+		// Not counted: failed, silent, not labeled or too old.
+		delivery{Repo: "octo/repo", Number: 4, Action: "labeled", DeliveryStatus: "failed", Received: at(1, 0)},
+		delivery{Repo: "octo/repo", Number: 5, Action: "labeled", DeliveryStatus: "silent", Received: at(1, 0)},
+		delivery{Repo: "octo/repo", Number: 6, Action: "opened", DeliveryStatus: "delivered", Received: at(1, 0)},
+		delivery{Repo: "octo/repo", Number: 7, Action: "labeled", DeliveryStatus: "delivered", Received: at(-1, 0)},
+	}
+	reviews := map[string][]review{
+		"octo/repo#1": []review{reviewAt("bob", at(0, 12)), reviewAt("alice", at(1, 2)), reviewAt("bob", at(1, 6))},
+		"octo/repo#2": []review{reviewAt("alice", at(3, 4))},
+		"octo/repo#4": []review{reviewAt("carol", at(1, 1))},
+	}
+	st := weeklyStats(deliveries, reviews, since)
+	if st.Labeled != 3 || st.Reviewed != 2 {
+		t.Errorf("labeled %d, reviewed %d, want 3 and 2", st.Labeled, st.Reviewed)
+	}
+	if st.Average != 3*time.Hour {
+		t.Errorf("average time to review = %s, want 3h", st.Average)
+	}
+	want := []reviewerCount{reviewerCount{"alice", 2}, reviewerCount{"bob", 1}}
+	if len(st.Reviewers) != len(want) || st.Reviewers[0] != want[0] || st.Reviewers[1] != want[1] {
+		t.Errorf("reviewers = %+v, want %+v", st.Reviewers, want)
+	}
+	text := "*Weekly review stats*\n" +
+		"Pull requests labeled: 3\n" +
+		"Average time to review: 3h 0m\n" +
+		"Top reviewers: alice (2), bob (1)"
+	if got := (notifier{}).statsText(st); got != text {
+		t.Errorf("text = %q, want %q", got, text)
+	}
+}
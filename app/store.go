@@ -0,0 +1,233 @@
+package pulltabs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/memcache"
+)
+
+// delivery is stored once per GitHub webhook delivery, keyed by the
+// X-GitHub-Delivery header. The event fields and delivery results are only
+// filled in when auditing is enabled.
+type delivery struct {
+	Received       time.Time
+	Repo           string
+	Number         int
+	Action         string
+	Label          string
+	DeliveryStatus string `datastore:"delivery_status"`
+	Attempts       int    `datastore:"attempts"`
+}
+
+// deliveryStatus summarizes how many of the backends were posted to.
+func deliveryStatus(posted, total int) string {
+	switch posted {
+	case total:
+		return "delivered"
+	case 0:
+		return "failed"
+	}
+	return "partial"
+}
+
+// claimDelivery records a delivery ID inside a transaction. It reports false
+// when the delivery had already been recorded, so that of any number of
+// concurrent handlers for one delivery exactly one gets true.
+func claimDelivery(c appengine.Context, id string, now time.Time) (bool, error) {
+	key := datastore.NewKey(c, "Delivery", id, 0, nil)
+	var first bool
+	err := datastore.RunInTransaction(c, func(tc appengine.Context) error {
+		var d delivery
+		err := datastore.Get(tc, key, &d)
+		if err == nil {
+			first = false
+			return nil
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		d.Received = now
+		_, err = datastore.Put(tc, key, &d)
+		first = err == nil
+		return err
+	}, nil)
+	return first, err
+}
+
+// recordDelivery saves the outcome of notifying about an event, keeping the
+// received time of a delivery already claimed for de-duplication.
+func recordDelivery(c appengine.Context, id string, pr pullRequestPost, status string, attempts int, now time.Time) error {
+	var d delivery
+	key := datastore.NewIncompleteKey(c, "Delivery", nil)
+	if id != "" {
+		key = datastore.NewKey(c, "Delivery", id, 0, nil)
+		if err := datastore.Get(c, key, &d); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+	}
+	if d.Received.IsZero() {
+		d.Received = now
+	}
+	d.Repo = pr.Repository.FullName
+	d.Number = pr.Number
+	d.Action = pr.Action
+	d.Label = pr.Label.Name
+	d.DeliveryStatus = status
+	d.Attempts = attempts
+	_, err := datastore.Put(c, key, &d)
+	return err
+}
+
+// thread is a Slack message posted for a pull request by a "slackbot"
+// backend, which later messages about the pull request reply to.
+type thread struct {
+	Repo    string
+	Number  int
+	Backend string // Channel as configured on the backend
+	Channel string // Channel ID returned by Slack
+	TS      string
+	Posted  time.Time
+}
+
+func saveThread(c appengine.Context, pr pullRequestPost, backend string, res slackAPIResponse, now time.Time) error {
+	name := fmt.Sprintf("%s#%d@%s", pr.Repository.FullName, pr.Number, backend)
+	key := datastore.NewKey(c, "Thread", name, 0, nil)
+	t := thread{
+		Repo:    pr.Repository.FullName,
+		Number:  pr.Number,
+		Backend: backend,
+		Channel: res.Channel,
+		TS:      res.TS,
+		Posted:  now,
+	}
+	_, err := datastore.Put(c, key, &t)
+	return err
+}
+
+func findThreads(c appengine.Context, repo string, number int) ([]thread, error) {
+	var threads []thread
+	q := datastore.NewQuery("Thread").
+		Filter("Repo =", repo).
+		Filter("Number =", number)
+	_, err := q.GetAll(c, &threads)
+	return threads, err
+}
+
+// findThread finds the pull request thread of a Slack message.
+func findThread(c appengine.Context, channel, ts string) (thread, bool, error) {
+	var threads []thread
+	q := datastore.NewQuery("Thread").
+		Filter("Channel =", channel).
+		Filter("TS =", ts).
+		Limit(1)
+	if _, err := q.GetAll(c, &threads); err != nil || len(threads) == 0 {
+		return thread{}, false, err
+	}
+	return threads[0], true, nil
+}
+
+// anchor is the daily Slack message that a label's notifications are posted
+// under, keyed by label, channel and day. It has no TS while being posted.
+type anchor struct {
+	Channel string
+	TS      string
+}
+
+func anchorKey(c appengine.Context, label, backend, day string) *datastore.Key {
+	return datastore.NewKey(c, "Anchor", fmt.Sprintf("%s@%s@%s", label, backend, day), 0, nil)
+}
+
+const (
+	// anchorPolls is how many times a delivery checks for an anchor that
+	// another delivery is posting.
+	anchorPolls = 10
+	// anchorPollInterval is the wait between those checks.
+	anchorPollInterval = 200 * time.Millisecond
+)
+
+// claimAnchor gets an anchor, or claims its key with an empty anchor when
+// there is none yet so that only one delivery posts it.
+func claimAnchor(c appengine.Context, key *datastore.Key) (anchor, bool, error) {
+	var a anchor
+	var claimed bool
+	err := datastore.RunInTransaction(c, func(tc appengine.Context) error {
+		err := datastore.Get(tc, key, &a)
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		_, err = datastore.Put(tc, key, &a)
+		claimed = err == nil
+		return err
+	}, nil)
+	return a, claimed, err
+}
+
+// repoCount is the number of pull request events processed for a repository.
+type repoCount struct {
+	Repo  string
+	Count int
+}
+
+func countEvent(c appengine.Context, repo string) error {
+	key := datastore.NewKey(c, "RepoCount", repo, 0, nil)
+	return datastore.RunInTransaction(c, func(tc appengine.Context) error {
+		r := repoCount{Repo: repo}
+		if err := datastore.Get(tc, key, &r); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		r.Count++
+		_, err := datastore.Put(tc, key, &r)
+		return err
+	}, nil)
+}
+
+func repoCounts(c appengine.Context) ([]repoCount, error) {
+	var counts []repoCount
+	_, err := datastore.NewQuery("RepoCount").Order("Repo").GetAll(c, &counts)
+	return counts, err
+}
+
+// rotation tracks whose turn it is in the Responders pool.
+type rotation struct {
+	Next int
+}
+
+// nextResponder picks the next reviewer from the pool and advances the
+// rotation for the following pull request.
+func nextResponder(c appengine.Context, pool []string) (string, error) {
+	key := datastore.NewKey(c, "Rotation", "responders", 0, nil)
+	var picked string
+	err := datastore.RunInTransaction(c, func(tc appengine.Context) error {
+		var r rotation
+		if err := datastore.Get(tc, key, &r); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		picked = pool[r.Next%len(pool)]
+		r.Next = (r.Next + 1) % len(pool)
+		_, err := datastore.Put(tc, key, &r)
+		return err
+	}, nil)
+	return picked, err
+}
+
+// contentKey identifies a rendered message for a pull request and backend.
+func contentKey(pr pullRequestPost, b Backend, body string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s#%d\x00%s\x00%s", pr.Repository.FullName, pr.Number, b.endpoint(), body)))
+	return fmt.Sprintf("content:%x", sum)
+}
+
+// claimContent reports whether a message may be posted, remembering it for
+// the window so identical messages are skipped. Memcache errors allow the post.
+func claimContent(c appengine.Context, key string, window time.Duration) bool {
+	err := memcache.Add(c, &memcache.Item{Key: key, Value: []byte{1}, Expiration: window})
+	return err != memcache.ErrNotStored
+}
+
+// releaseContent forgets a message that could not be posted.
+func releaseContent(c appengine.Context, key string) {
+	memcache.Delete(c, key)
+}
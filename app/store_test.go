@@ -0,0 +1,164 @@
+package pulltabs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"appengine/aetest"
+	"appengine/datastore"
+	"appengine/taskqueue"
+)
+
+func TestClaimDeliveryConcurrent(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	const delivery = "72d3162e-cc78-11e3-81ab-4c9367dc0958"
+	s := notifier{Label: "awaiting review", Dedup: true}
+	body := `{"action": "labeled", "label": {"name": "awaiting review"}, "pull_request": {"state": "open"}}`
+	queued := len(taskqueue.Added)
+
+	const handlers = 2
+	var wg sync.WaitGroup
+	scheduled := make(chan bool, handlers)
+	for i := 0; i < handlers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lc := &logContext{Context: c}
+			req := httptest.NewRequest("POST", "/payload", strings.NewReader(body))
+			req.Header.Set("X-GitHub-Event", "pull_request")
+			req.Header.Set("X-GitHub-Delivery", delivery)
+			w := httptest.NewRecorder()
+			s.payload(lc, w, req)
+			if w.Code != http.StatusOK {
+				t.Errorf("payload = %d, want 200", w.Code)
+			}
+			scheduled <- lc.logged("Deferred notification")
+		}()
+	}
+	wg.Wait()
+	close(scheduled)
+	posts := 0
+	for ok := range scheduled {
+		if ok {
+			posts++
+		}
+	}
+	if posts != 1 {
+		t.Errorf("%d handlers scheduled the delivery, want exactly 1", posts)
+	}
+	tasks := taskqueue.Added[queued:]
+	if len(tasks) != 1 || tasks[0].Header["X-GitHub-Delivery"][0] != delivery {
+		t.Errorf("queued %d deliveries, want exactly 1", len(tasks))
+	}
+
+	first, err := claimDelivery(c, "9a1b6c2e-cc78-11e3-81ab-4c9367dc0958", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first {
+		t.Error("another delivery was not claimed")
+	}
+}
+
+func TestAuditRetriedDelivery(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var mu sync.Mutex
+	calls := 0
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if calls++; calls == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer flaky.Close()
+
+	s := notifier{SlackURL: flaky.URL, Retries: 2, Audit: true}
+	pr := testEvent(t, `{"action": "labeled", "number": 1, "repository": {"full_name": "octo/repo"}}`)
+	s.notify(c, pr, "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+
+	var d delivery
+	if err := datastore.Get(c, datastore.NewKey(c, "Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958", 0, nil), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.DeliveryStatus != "delivered" || d.Attempts != 2 {
+		t.Errorf("recorded %s after %d attempts, want delivered after 2", d.DeliveryStatus, d.Attempts)
+	}
+	if d.Repo != "octo/repo" || d.Number != 1 || d.Action != "labeled" {
+		t.Errorf("recorded event %s#%d %s, want octo/repo#1 labeled", d.Repo, d.Number, d.Action)
+	}
+}
+
+func TestDeliveryStatus(t *testing.T) {
+	tests := []struct {
+		posted, total int
+		want          string
+	}{
+		{2, 2, "delivered"},
+		{1, 2, "partial"},
+		{0, 2, "failed"},
+	}
+	for _, tt := range tests {
+		if got := deliveryStatus(tt.posted, tt.total); got != tt.want {
+			t.Errorf("deliveryStatus(%d, %d) = %q, want %q", tt.posted, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestNextResponder(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	pool := []string{"alice", "bob", "carol"}
+	for i, want := range []string{"alice", "bob", "carol", "alice"} {
+		got, err := nextResponder(c, pool)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("pull request %d: responder = %s, want %s", i+1, got, want)
+		}
+	}
+}
+
+func TestSilentLabel(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := notifier{Label: "review", SilentLabels: []string{"review-later"}}
+	_, lc := servePayload(c, s, "pull_request", `{"action": "labeled", "number": 2, "label": {"name": "review-later"}, "repository": {"full_name": "octo/repo"}, "pull_request": {"state": "open"}}`)
+	if lc.logged("Deferred notification") {
+		t.Error("silent label was notified")
+	}
+	var recorded []delivery
+	if _, err := datastore.NewQuery("Delivery").GetAll(c, &recorded); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 1 || recorded[0].DeliveryStatus != "silent" || recorded[0].Label != "review-later" {
+		t.Errorf("recorded %+v, want one silent delivery", recorded)
+	}
+
+	_, lc = servePayload(c, s, "pull_request", `{"action": "labeled", "number": 2, "label": {"name": "review"}, "repository": {"full_name": "octo/repo"}, "pull_request": {"state": "open"}}`)
+	if !lc.logged("Deferred notification") {
+		t.Error("normal label was not notified")
+	}
+}
@@ -0,0 +1,54 @@
+package pulltabs
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+)
+
+const telegramAPIURL = "https://api.telegram.org/"
+
+type telegramMessage struct {
+	ChatID                string `json:"chat_id"`
+	Text                  string `json:"text"`
+	ParseMode             string `json:"parse_mode"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview,omitempty"`
+}
+
+// telegramHTML converts Slack text for Telegram's HTML parse mode, turning
+// Slack <url|text> links into anchors.
+func telegramHTML(text string) string {
+	escape := func(s string) string {
+		return html.EscapeString(slackUnescaper.Replace(s))
+	}
+	var out []string
+	last := 0
+	for _, m := range slackLinkPattern.FindAllStringSubmatchIndex(text, -1) {
+		out = append(out, escape(text[last:m[0]]))
+		out = append(out, fmt.Sprintf(`<a href="%s">%s</a>`, escape(text[m[2]:m[3]]), escape(text[m[4]:m[5]])))
+		last = m[1]
+	}
+	out = append(out, escape(text[last:]))
+	return strings.Join(out, "")
+}
+
+func (s notifier) telegramOutput(b Backend, pr pullRequestPost, d prDetails) ([]byte, error) {
+	lines := []string{
+		"<b>" + html.EscapeString(s.text(pr)) + "</b>",
+		fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(pr.PullRequest.HTMLURL), html.EscapeString(pr.PullRequest.Title)),
+	}
+	for _, l := range s.details(pr, d) {
+		lines = append(lines, telegramHTML(l))
+	}
+	for _, f := range s.fields(pr, d) {
+		lines = append(lines, "<b>"+html.EscapeString(f.Title)+"</b>: "+telegramHTML(f.Value))
+	}
+	m := telegramMessage{
+		ChatID:                b.Channel,
+		Text:                  strings.Join(lines, "\n"),
+		ParseMode:             "HTML",
+		DisableWebPagePreview: !s.PreviewImage,
+	}
+	return json.Marshal(&m)
+}
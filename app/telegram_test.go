@@ -0,0 +1,70 @@
+package pulltabs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestTelegramHTML(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"plain text", "plain text"},
+		{"by <https://github.com/octocat|octocat>", `by <a href="https://github.com/octocat">octocat</a>`},
+		{"<https://example.com/?a=1&amp;b=2|a &amp; b> & <i>", `<a href="https://example.com/?a=1&amp;b=2">a &amp; b</a> &amp; &lt;i&gt;`},
+		{"Fixes &lt;b&gt;bold&lt;/b&gt;", "Fixes &lt;b&gt;bold&lt;/b&gt;"},
+	}
+	for _, tt := range tests {
+		if got := telegramHTML(tt.text); got != tt.want {
+			t.Errorf("telegramHTML(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTelegramRequest(t *testing.T) {
+	s := notifier{ShowAuthor: true}
+	b := Backend{Type: "telegram", Token: "123456:ABC-DEF", Channel: "-1001234567890"}
+	pr := testEvent(t, `{"pull_request": {"title": "Fix <script> tags", "html_url": "https://github.com/octo/repo/pull/4", "user": {"login": "octocat"}}}`)
+	body, err := s.render(b, pr, prDetails{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, st := stubClient(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok": true}`))
+	})
+	if _, err := postBody(client, b, body); err != nil {
+		t.Fatal(err)
+	}
+	req := st.requests[0]
+	if req.URL.String() != "https://api.telegram.org/bot123456:ABC-DEF/sendMessage" {
+		t.Errorf("posted to %s, want sendMessage", req.URL)
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want JSON", ct)
+	}
+	var m telegramMessage
+	if err := json.Unmarshal([]byte(st.bodies[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.ChatID != b.Channel || m.ParseMode != "HTML" || !m.DisableWebPagePreview {
+		t.Errorf("message = %+v, want the chat ID, HTML and no preview", m)
+	}
+	want := "<b>A Pull Request requires review</b>\n" +
+		`<a href="https://github.com/octo/repo/pull/4">Fix &lt;script&gt; tags</a>` + "\n" +
+		`by <a href="https://github.com/octocat">octocat</a>`
+	if m.Text != want {
+		t.Errorf("text = %q, want %q", m.Text, want)
+	}
+}
+
+func TestWithoutURL(t *testing.T) {
+	err := &url.Error{Op: "Post", URL: "https://api.telegram.org/bot123456:ABC-DEF/sendMessage", Err: errors.New("connection refused")}
+	if got := withoutURL(err).Error(); strings.Contains(got, "ABC-DEF") || got != "connection refused" {
+		t.Errorf("withoutURL = %q, want the error without the token", got)
+	}
+}
@@ -0,0 +1,124 @@
+package pulltabs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"appengine"
+	"appengine/urlfetch"
+)
+
+// startup holds the result of the CheckOnStart check of this instance.
+var startup struct {
+	mu  sync.Mutex
+	err error
+}
+
+func startupError() error {
+	startup.mu.Lock()
+	defer startup.mu.Unlock()
+	return startup.err
+}
+
+// validateWebhook posts an empty message to a Slack incoming webhook. Slack
+// rejects it with "no_text" without posting anything when the URL is valid.
+func validateWebhook(client *http.Client, u string) error {
+	r, err := client.Post(u, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		return withoutURL(err)
+	}
+	defer r.Body.Close()
+	body, _ := ioutil.ReadAll(r.Body)
+	if r.StatusCode == http.StatusBadRequest && strings.TrimSpace(string(body)) == "no_text" {
+		return nil
+	}
+	return fmt.Errorf("unexpected response %s: %s", r.Status, body)
+}
+
+// expectOK makes a GET request that must succeed, with a bearer token when
+// one is given.
+func expectOK(client *http.Client, u, token string) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return withoutURL(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	r, err := client.Do(req)
+	if err != nil {
+		return withoutURL(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response %s", r.Status)
+	}
+	return nil
+}
+
+// validateHead checks a URL with a HEAD request. Client errors fail, except
+// 405 from endpoints that only take POST, which still shows the URL exists.
+func validateHead(client *http.Client, u string) error {
+	r, err := client.Head(u)
+	if err != nil {
+		return withoutURL(err)
+	}
+	r.Body.Close()
+	if r.StatusCode >= 400 && r.StatusCode != http.StatusMethodNotAllowed {
+		return fmt.Errorf("unexpected response %s", r.Status)
+	}
+	return nil
+}
+
+// validate checks that every backend accepts its credentials, without posting
+// any messages.
+func (s notifier) validate(c appengine.Context, client *http.Client) error {
+	for _, b := range s.allBackends() {
+		var err error
+		switch b.Type {
+		case "slackbot":
+			_, err = slackAPI(client, b.Token, "auth.test", []byte("{}"))
+		case "slack", "":
+			err = validateWebhook(client, b.URL)
+		case "telegram":
+			err = expectOK(client, telegramAPIURL+"bot"+b.Token+"/getMe", "")
+		case "discord":
+			// Discord describes a webhook in response to a GET on its URL.
+			err = expectOK(client, b.URL, "")
+		case "pubsub":
+			var token string
+			if token, _, err = appengine.AccessToken(c, pubsubScope); err == nil {
+				err = expectOK(client, pubsubAPIURL+b.Topic, token)
+			}
+		default:
+			err = validateHead(client, b.URL)
+		}
+		if err != nil {
+			return fmt.Errorf("%s backend: %s", b.Type, err)
+		}
+	}
+	return nil
+}
+
+// warmup runs CheckOnStart when App Engine starts an instance. A failure
+// fails the warmup request and the health check of the instance.
+func (s notifier) warmup(c appengine.Context, w http.ResponseWriter, req *http.Request) {
+	if !s.CheckOnStart {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	err := s.validate(c, urlfetch.Client(c))
+	startup.mu.Lock()
+	startup.err = err
+	startup.mu.Unlock()
+	if err != nil {
+		c.Errorf("Startup validation failed for request %s. Error: %s", appengine.RequestID(c), err)
+		http.Error(w, "Startup validation failed", http.StatusInternalServerError)
+		return
+	}
+	c.Infof("Startup validation passed for request %s", appengine.RequestID(c))
+	w.WriteHeader(http.StatusOK)
+}
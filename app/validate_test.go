@@ -0,0 +1,92 @@
+package pulltabs
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"appengine/aetest"
+)
+
+// validBackends answers each backend's credential check, rejecting the
+// credentials containing "bad".
+func validBackends(w http.ResponseWriter, r *http.Request) {
+	bad := strings.Contains(r.URL.Path, "bad") || r.Header.Get("Authorization") == "Bearer xoxb-bad"
+	switch {
+	case r.URL.Path == "/api/auth.test" && bad:
+		io.WriteString(w, `{"ok": false, "error": "invalid_auth"}`)
+	case r.URL.Path == "/api/auth.test":
+		io.WriteString(w, `{"ok": true}`)
+	case strings.HasPrefix(r.URL.Path, "/services/") && bad:
+		http.Error(w, "no_service", http.StatusNotFound)
+	case strings.HasPrefix(r.URL.Path, "/services/"):
+		http.Error(w, "no_text", http.StatusBadRequest)
+	case bad:
+		http.NotFound(w, r)
+	case r.Method == "HEAD" && r.URL.Path == "/hooks/post-only":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	client, _ := stubClient(validBackends)
+	for _, tc := range []struct {
+		backend Backend
+		err     string
+	}{
+		{Backend{Type: "slack", URL: "https://hooks.slack.com/services/T0/B0/good"}, ""},
+		{Backend{Type: "slack", URL: "https://hooks.slack.com/services/T0/B0/bad"}, "slack backend: unexpected response 404 Not Found: no_service"},
+		{Backend{Type: "slackbot", Token: "xoxb-good"}, ""},
+		{Backend{Type: "slackbot", Token: "xoxb-bad"}, "slackbot backend: "},
+		{Backend{Type: "telegram", Token: "good"}, ""},
+		{Backend{Type: "telegram", Token: "bad"}, "telegram backend: unexpected response 404 Not Found"},
+		{Backend{Type: "discord", URL: "https://discord.com/api/webhooks/1/good"}, ""},
+		{Backend{Type: "webhook", URL: "https://example.com/hooks/post-only"}, ""},
+		{Backend{Type: "webhook", URL: "https://example.com/hooks/bad"}, "webhook backend: unexpected response 404 Not Found"},
+	} {
+		err := notifier{Backends: []Backend{tc.backend}}.validate(c, client)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s %s: validate = %q, want nil", tc.backend.Type, tc.backend.endpoint(), err)
+		case tc.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tc.err)):
+			t.Errorf("%s %s: validate = %v, want %q", tc.backend.Type, tc.backend.endpoint(), err, tc.err)
+		}
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	defer func() { startup.err = nil }()
+
+	missing := newHook(http.StatusNotFound)
+	defer missing.Close()
+	for _, tc := range []struct {
+		check  bool
+		status int
+	}{
+		{false, http.StatusOK},
+		{true, http.StatusInternalServerError},
+	} {
+		s := notifier{Backends: []Backend{Backend{Type: "webhook", URL: missing.URL}}, CheckOnStart: tc.check}
+		w := httptest.NewRecorder()
+		s.warmup(c, w, httptest.NewRequest("GET", "/_ah/warmup", nil))
+		if w.Code != tc.status {
+			t.Errorf("CheckOnStart %t: warmup = %d, want %d", tc.check, w.Code, tc.status)
+		}
+		if failed := startupError() != nil; failed != tc.check {
+			t.Errorf("CheckOnStart %t: startup error = %v", tc.check, startupError())
+		}
+	}
+}
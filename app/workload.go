@@ -0,0 +1,66 @@
+package pulltabs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+// reviewRequests holds the reviewers still requested on an open pull request.
+// A reviewer's workload is the number of these listing them.
+type reviewRequests struct {
+	Reviewers []string
+}
+
+// trackReviews saves the requested reviewers of a pull request, or forgets
+// them once it is closed.
+func trackReviews(c appengine.Context, pr pullRequestPost) error {
+	name := fmt.Sprintf("%s#%d", pr.Repository.FullName, pr.Number)
+	key := datastore.NewKey(c, "ReviewRequests", name, 0, nil)
+	if pr.Action == "closed" || len(pr.PullRequest.RequestedReviewers) == 0 {
+		err := datastore.Delete(c, key)
+		if err == datastore.ErrNoSuchEntity {
+			err = nil
+		}
+		return err
+	}
+	var r reviewRequests
+	for _, rr := range pr.PullRequest.RequestedReviewers {
+		r.Reviewers = append(r.Reviewers, rr.Login)
+	}
+	_, err := datastore.Put(c, key, &r)
+	return err
+}
+
+// workload counts the open review requests of each reviewer.
+func workload(c appengine.Context) (map[string]int, error) {
+	var all []reviewRequests
+	if _, err := datastore.NewQuery("ReviewRequests").GetAll(c, &all); err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, r := range all {
+		for _, login := range r.Reviewers {
+			counts[login]++
+		}
+	}
+	return counts, nil
+}
+
+func (s notifier) adminWorkload(c appengine.Context, w http.ResponseWriter, req *http.Request) {
+	reqID := appengine.RequestID(c)
+	counts, err := workload(c)
+	if err != nil {
+		c.Infof("Failed to count review requests for request %s. Error: %s", reqID, err)
+		http.Error(w, "Could not count review requests", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("CONTENT-TYPE", "application/json; charset=UTF-8")
+	w.Header().Set("CACHE-CONTROL", "max-age=0, no-cache")
+	if err := json.NewEncoder(w).Encode(counts); err != nil {
+		c.Infof("Failed to write workload for request %s. Error: %s", reqID, err)
+	}
+}
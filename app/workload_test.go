@@ -0,0 +1,73 @@
+package pulltabs
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"appengine/aetest"
+)
+
+func TestWorkload(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	steps := []struct {
+		event string
+		want  map[string]int
+	}{
+		{`{"action": "review_requested", "number": 1, "repository": {"full_name": "octo/repo"}, "pull_request": {"requested_reviewers": [{"login": "alice"}, {"login": "bob"}]}}`, map[string]int{"alice": 1, "bob": 1}},
+		{`{"action": "review_requested", "number": 2, "repository": {"full_name": "octo/repo"}, "pull_request": {"requested_reviewers": [{"login": "alice"}]}}`, map[string]int{"alice": 2, "bob": 1}},
+		{`{"action": "labeled", "number": 1, "repository": {"full_name": "octo/repo"}, "pull_request": {"requested_reviewers": [{"login": "alice"}, {"login": "bob"}]}}`, map[string]int{"alice": 2, "bob": 1}},
+		{`{"action": "review_request_removed", "number": 1, "repository": {"full_name": "octo/repo"}, "pull_request": {"requested_reviewers": [{"login": "bob"}]}}`, map[string]int{"alice": 1, "bob": 1}},
+		{`{"action": "closed", "number": 2, "repository": {"full_name": "octo/repo"}, "pull_request": {"requested_reviewers": [{"login": "alice"}]}}`, map[string]int{"bob": 1}},
+		{`{"action": "closed", "number": 1, "repository": {"full_name": "octo/repo"}, "pull_request": {"requested_reviewers": [{"login": "bob"}]}}`, map[string]int{}},
+	}
+	for i, step := range steps {
+		if err := trackReviews(c, testEvent(t, step.event)); err != nil {
+			t.Fatal(err)
+		}
+		counts, err := workload(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(counts) != len(step.want) {
+			t.Errorf("step %d: workload = %v, want %v", i, counts, step.want)
+			continue
+		}
+		for login, n := range step.want {
+			if counts[login] != n {
+				t.Errorf("step %d: workload = %v, want %v", i, counts, step.want)
+			}
+		}
+	}
+}
+
+func TestWorkloadDetails(t *testing.T) {
+	s := notifier{ShowWorkload: true}
+	pr := testEvent(t, `{"pull_request": {"requested_reviewers": [{"login": "alice"}, {"login": "bob"}]}}`)
+	lines := s.details(pr, prDetails{Workload: map[string]int{"alice": 3}})
+	if len(lines) != 1 || lines[0] != "alice has 3 open review requests" {
+		t.Errorf("details = %q, want alice's workload", lines)
+	}
+}
+
+func TestAdminWorkload(t *testing.T) {
+	c, err := aetest.NewContext(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := trackReviews(c, testEvent(t, `{"number": 1, "repository": {"full_name": "octo/repo"}, "pull_request": {"requested_reviewers": [{"login": "alice"}]}}`)); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	notifier{}.adminWorkload(c, w, httptest.NewRequest("GET", "/admin/workload", nil))
+	if got := strings.TrimSpace(w.Body.String()); got != `{"alice":1}` {
+		t.Errorf("workload = %s, want alice's count", got)
+	}
+}